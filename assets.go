@@ -1,12 +1,39 @@
 package vpp
 
+import (
+	"context"
+	"fmt"
+)
+
 type PricingParam string
 
 const (
 	PricingParamStd  PricingParam = "STDQ" // Standard Quality
-	PricingParamPlus              = "PLUS" // High Quality (Books only)
+	PricingParamPlus PricingParam = "PLUS" // High Quality (Books only)
+)
+
+// ProductType identifies the kind of product an asset is, as documented in the VPP Managed Distribution guide.
+type ProductType int
+
+const (
+	ProductTypeMacSoftware ProductType = 7  // OS X software
+	ProductTypeApp         ProductType = 8  // iOS or OS X App Store app
+	ProductTypeBook        ProductType = 10 // iBooks Store book
 )
 
+func (t ProductType) String() string {
+	switch t {
+	case ProductTypeMacSoftware:
+		return "OS X software"
+	case ProductTypeApp:
+		return "app"
+	case ProductTypeBook:
+		return "book"
+	default:
+		return fmt.Sprintf("ProductType(%d)", int(t))
+	}
+}
+
 // VPPAssetAssignment represents a single asset/product and its currently available/assigned licenses totals.
 type VPPAssetAssignment struct {
 	AdamIdStr        string       `json:"adamIdStr"`
@@ -15,51 +42,160 @@ type VPPAssetAssignment struct {
 	DeviceAssignable bool         `json:"deviceAssignable"`
 	IsIrrevocable    bool         `json:"isIrrevocable"`
 	PricingParam     PricingParam `json:"pricingParam"`
-	ProductTypeId    int          `json:"productTypeId"`
+	ProductTypeId    ProductType  `json:"productTypeId"`
 	ProductTypeName  string       `json:"productTypeName"`
 	RetiredCount     int          `json:"retiredCount"`
 	TotalCount       int          `json:"totalCount"`
+
+	// Metadata is the store listing of the asset, set by AddMetadata.
+	Metadata *AssetMetadata `json:"-"`
+}
+
+// Utilization returns the fraction of licenses which are assigned, between 0 and 1.
+// It is only meaningful if the license counts were requested.
+func (a VPPAssetAssignment) Utilization() float64 {
+	if a.TotalCount == 0 {
+		return 0
+	}
+	return float64(a.AssignedCount) / float64(a.TotalCount)
+}
+
+// Exhausted reports whether licenses were purchased for the asset but none are available to be assigned.
+// It is only meaningful if the license counts were requested.
+func (a VPPAssetAssignment) Exhausted() bool {
+	return a.TotalCount > 0 && a.AvailableCount <= 0
+}
+
+// Assets is a list of assets returned by GetAssets.
+type Assets []VPPAssetAssignment
+
+// ByAdamID finds an asset by its adam id.
+// Books purchased in both qualities are listed once per pricing param, in which case the first is returned.
+func (a Assets) ByAdamID(adamID string) (VPPAssetAssignment, bool) {
+	for _, asset := range a {
+		if asset.AdamIdStr == adamID {
+			return asset, true
+		}
+	}
+	return VPPAssetAssignment{}, false
+}
+
+// Exhausted returns the assets which have no licenses available to be assigned.
+func (a Assets) Exhausted() Assets {
+	var exhausted Assets
+	for _, asset := range a {
+		if asset.Exhausted() {
+			exhausted = append(exhausted, asset)
+		}
+	}
+	return exhausted
+}
+
+// Utilization returns the fraction of licenses which are assigned across all of the assets, between 0 and 1.
+func (a Assets) Utilization() float64 {
+	var assigned, total int
+	for _, asset := range a {
+		assigned += asset.AssignedCount
+		total += asset.TotalCount
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(assigned) / float64(total)
 }
 
 // VPPAsset represents a single licenseable item
 type VPPAsset struct {
 	AdamID          string       `json:"adamIdStr,omitempty"`
-	ProductTypeID   int          `json:"productTypeId,omitempty"`
+	ProductTypeID   ProductType  `json:"productTypeId,omitempty"`
 	PricingParam    PricingParam `json:"pricingParam,omitempty"`
 	ProductTypeName string       `json:"productTypeName"`
 }
 
 // AssetsService describes an interface that is capable of reporting on VPP assets and their license counts.
 type AssetsService interface {
-	GetAssets(includeLicenseCounts bool) ([]VPPAssetAssignment, error)
+	GetAssets(includeLicenseCounts bool, opts ...GetAssetsOption) (Assets, error)
+	GetAssetsWithContext(ctx context.Context, includeLicenseCounts bool, opts ...GetAssetsOption) (Assets, error)
 }
 
 type assetsService struct {
 	client *vppClient
-	sToken string
+}
+
+type getAssetsRequestOpts struct {
+	PricingParam  PricingParam `json:"pricingParam,omitempty"`
+	ProductTypeID ProductType  `json:"productTypeId,omitempty"`
+}
+
+// GetAssetsOption describes the signature of the closure returned by a function adding an argument to GetAssets
+type GetAssetsOption func(*getAssetsRequestOpts) error
+
+// AssetPricingParam is an argument given to GetAssets to only return assets of the given quality.
+func AssetPricingParam(pricingParam PricingParam) GetAssetsOption {
+	return func(opts *getAssetsRequestOpts) error {
+		if pricingParam != PricingParamStd && pricingParam != PricingParamPlus {
+			return fmt.Errorf("unknown pricing param %q", pricingParam)
+		}
+		opts.PricingParam = pricingParam
+		return nil
+	}
+}
+
+// AssetProductType is an argument given to GetAssets to only return assets of the given product type.
+func AssetProductType(productType ProductType) GetAssetsOption {
+	return func(opts *getAssetsRequestOpts) error {
+		if productType <= 0 {
+			return fmt.Errorf("invalid product type %d", int(productType))
+		}
+		opts.ProductTypeID = productType
+		return nil
+	}
 }
 
 type getVPPAssetsSrvRequest struct {
+	*getAssetsRequestOpts
 	IncludeLicenseCounts bool   `json:"includeLicenseCounts,omitempty"`
 	SToken               string `json:"sToken"`
 }
 
 type getVPPAssetsSrvResponse struct {
-	Status Status               `json:"status,omitempty"`
-	Assets []VPPAssetAssignment `json:"assets,omitempty"`
+	Status Status `json:"status,omitempty"`
+	Assets Assets `json:"assets,omitempty"`
 	*VPPError
 }
 
 // Get a list of VPP assets and their license counts.
 // Note that if you use includeLicenseCounts the client may be deemed as overloading the VPP service and the next
-// request may be delayed.
-func (s *assetsService) GetAssets(includeLicenseCounts bool) ([]VPPAssetAssignment, error) {
+// request may be delayed. WithEndpointRateLimit can be used to space out requests to getVPPAssetsSrv.
+func (s *assetsService) GetAssets(includeLicenseCounts bool, opts ...GetAssetsOption) (Assets, error) {
+	return s.GetAssetsWithContext(context.Background(), includeLicenseCounts, opts...)
+}
+
+// GetAssetsWithContext is like GetAssets but carries a context for cancellation and deadlines.
+func (s *assetsService) GetAssetsWithContext(ctx context.Context, includeLicenseCounts bool, opts ...GetAssetsOption) (Assets, error) {
+	requestOpts := &getAssetsRequestOpts{}
+	for _, option := range opts {
+		if err := option(requestOpts); err != nil {
+			return nil, err
+		}
+	}
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var response *getVPPAssetsSrvResponse
 	var request *getVPPAssetsSrvRequest = &getVPPAssetsSrvRequest{
+		getAssetsRequestOpts: requestOpts,
 		IncludeLicenseCounts: includeLicenseCounts,
-		SToken:               s.sToken,
+		SToken:               sToken,
 	}
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.GetVPPAssetsSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.GetVPPAssetsSrvURL, request)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +206,7 @@ func (s *assetsService) GetAssets(includeLicenseCounts bool) ([]VPPAssetAssignme
 	}
 
 	if response.Status == StatusErr {
-		return nil, response.VPPError
+		return nil, s.client.vppError(response.VPPError)
 	}
 
 	return response.Assets, nil
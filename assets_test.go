@@ -0,0 +1,70 @@
+package vpp
+
+import (
+	"testing"
+
+	"github.com/mosen/vpp/vpptest"
+)
+
+func TestAssetsService_GetAssets(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726", PricingParam: "PLUS", ProductTypeID: 10, ProductTypeName: "Book", DeviceAssignable: true}, 2)
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "361309726", PricingParam: PricingParamPlus})
+	ops.AssignSerialNumber("C02AAAAAAAAA")
+	ops.AssignSerialNumber("C02BBBBBBBBB")
+	if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+		t.Fatal(err)
+	}
+
+	assets, err := vppClient.GetAssets(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 {
+		t.Fatalf("expected 2 assets, got %d", len(assets))
+	}
+
+	book, ok := assets.ByAdamID("361309726")
+	if !ok {
+		t.Fatal("expected to find the book by adam id")
+	}
+	if book.ProductTypeId != ProductTypeBook || !book.Exhausted() || book.Utilization() != 1 {
+		t.Errorf("expected an exhausted book, got %#v", book)
+	}
+
+	if exhausted := assets.Exhausted(); len(exhausted) != 1 || exhausted[0].AdamIdStr != "361309726" {
+		t.Errorf("expected only the book to be exhausted, got %#v", exhausted)
+	}
+
+	if utilization := assets.Utilization(); utilization != 2.0/12.0 {
+		t.Errorf("expected a utilization of 2/12, got %f", utilization)
+	}
+
+	books, err := vppClient.GetAssets(false, AssetProductType(ProductTypeBook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].AdamIdStr != "361309726" {
+		t.Errorf("expected only the book, got %#v", books)
+	}
+
+	standard, err := vppClient.GetAssets(false, AssetPricingParam(PricingParamStd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := standard.ByAdamID("361309726"); ok || len(standard) != 1 {
+		t.Errorf("expected only the standard quality app, got %#v", standard)
+	}
+
+	if _, err := vppClient.GetAssets(false, AssetPricingParam("HIGH")); err == nil {
+		t.Error("expected an error from an unknown pricing param")
+	}
+}
@@ -1,15 +1,96 @@
 package vpp
 
+import "context"
+
 // BatchRequestOpts is a structure that defines options available when fetching objects in batches (users/licenses)
 type BatchRequest struct {
 	BatchToken         string `json:"batchToken,omitempty"`
 	SinceModifiedToken string `json:"sinceModifiedToken,omitempty"`
+
+	// TotalCount and TotalBatchCount are estimates reported by the VPP service alongside the first batch.
+	TotalCount      int `json:"-"`
+	TotalBatchCount int `json:"-"`
 }
 
 func (br *BatchRequest) HasNext() bool {
 	return br.BatchToken != "" && br.SinceModifiedToken == ""
 }
 
+// update records the tokens and totals returned in a batched response.
+func (br *BatchRequest) update(batchToken, sinceModifiedToken string, totalCount, totalBatchCount int) {
+	br.BatchToken = batchToken
+	br.SinceModifiedToken = sinceModifiedToken
+	if totalCount != 0 {
+		br.TotalCount = totalCount
+	}
+	if totalBatchCount != 0 {
+		br.TotalBatchCount = totalBatchCount
+	}
+}
+
+// batchIterator implements the batch token handling shared by UsersIterator and LicensesIterator.
+type batchIterator struct {
+	ctx       context.Context
+	batch     BatchRequest
+	remaining int
+	done      bool
+	err       error
+}
+
+func newBatchIterator(ctx context.Context, batch *BatchRequest) batchIterator {
+	it := batchIterator{ctx: ctx}
+	if batch != nil {
+		it.batch = *batch
+	}
+	return it
+}
+
+// next fetches batches until one contains items or there are no batches left.
+// fetch is given the batch request to send and returns the number of items it received.
+func (it *batchIterator) next(fetch func(ctx context.Context, batch *BatchRequest) (int, error)) bool {
+	for it.remaining == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		count, err := fetch(it.ctx, &it.batch)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.remaining = count
+		it.done = !it.batch.HasNext()
+	}
+
+	it.remaining--
+	return true
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *batchIterator) Err() error {
+	return it.err
+}
+
+// TotalCount returns the estimated number of records reported by the VPP service, if known.
+func (it *batchIterator) TotalCount() int {
+	return it.batch.TotalCount
+}
+
+// SinceModifiedToken returns the token that should be given in the next request for modified records.
+// It is only available once the iterator has been exhausted without error.
+func (it *batchIterator) SinceModifiedToken() string {
+	if !it.done || it.remaining > 0 || it.err != nil {
+		return ""
+	}
+	return it.batch.SinceModifiedToken
+}
+
 // BatchOption describes the signature of a closure returned if you are adding a batch option to a request
 //type BatchOption func(*BatchRequest) error
 
@@ -0,0 +1,115 @@
+package vpp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CheckpointStore persists the sinceModifiedTokens of syncers between runs, keyed by name so that a single store can
+// hold the checkpoints of several syncers or sTokens.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the token saved under the key, or an empty string if there is none.
+	LoadCheckpoint(key string) (string, error)
+	SaveCheckpoint(key, token string) error
+}
+
+// MemoryCheckpointStore is a CheckpointStore which keeps checkpoints in memory, for the lifetime of the process.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]string
+}
+
+// NewMemoryCheckpointStore creates an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]string)}
+}
+
+func (s *MemoryCheckpointStore) LoadCheckpoint(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpoints[key], nil
+}
+
+func (s *MemoryCheckpointStore) SaveCheckpoint(key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[key] = token
+	return nil
+}
+
+// FileCheckpointStore is a CheckpointStore which keeps checkpoints in a JSON file.
+type FileCheckpointStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileCheckpointStore creates a store keeping checkpoints in the file at the given path, which is created when the
+// first checkpoint is saved.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (s *FileCheckpointStore) LoadCheckpoint(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	return checkpoints[key], nil
+}
+
+func (s *FileCheckpointStore) SaveCheckpoint(key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[key] = token
+
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// read returns the checkpoints in the file. The store must be locked by the caller.
+func (s *FileCheckpointStore) read() (map[string]string, error) {
+	checkpoints := make(map[string]string)
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
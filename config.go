@@ -1,15 +1,29 @@
 package vpp
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/satori/go.uuid"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	serviceConfigPath = "VPPServiceConfigSrv"
 )
 
+// DefaultServiceConfigTTL is how long a service configuration is used before it is fetched again.
+const DefaultServiceConfigTTL = 24 * time.Hour
+
+// serviceConfigRetryInterval is how long an expired service configuration continues to be used after it could not be
+// fetched again, before another attempt is made.
+const serviceConfigRetryInterval = time.Minute
+
+// ServiceConfig describes the URLs, limits and error codes published by the VPP service.
+// It can be marshalled to JSON and given to NewVPPClient via WithServiceConfig to start a client without contacting
+// the VPP service.
 type ServiceConfig struct {
 	InvitationEmailURL               string     `json:"invitationEmailUrl"`
 	RegisterUserSrvURL               string     `json:"registerUserSrvUrl"`
@@ -23,7 +37,6 @@ type ServiceConfig struct {
 	ClientConfigSrvURL               string     `json:"clientConfigSrvUrl"`
 	ErrorCodes                       []VPPError `json:"errorCodes"`
 	GetVPPAssetsSrvURL               string     `json:"getVPPAssetsSrvUrl"`
-	InvitationEmailUrl               string     `json:"invitationEmailUrl"`
 	ManageVPPLicensesByAdamIdSrvURL  string     `json:"manageVPPLicensesByAdamIdSrvUrl"`
 	MaxBatchAssociateLicenseCount    int        `json:"maxBatchAssociateLicenseCount"`
 	MaxBatchDisassociateLicenseCount int        `json:"maxBatchDisassociateLicenseCount"`
@@ -31,6 +44,16 @@ type ServiceConfig struct {
 	VPPWebsiteUrl                    string     `json:"vppWebsiteUrl"`
 }
 
+// LookupError finds the description of an error number in the error codes published by the VPP service.
+func (c *ServiceConfig) LookupError(errorNumber int) (VPPError, bool) {
+	for _, code := range c.ErrorCodes {
+		if code.ErrorNumber == errorNumber {
+			return code, true
+		}
+	}
+	return VPPError{}, false
+}
+
 // ClientContext represents the information about the current MDM which is stored with the VPP service to ensure
 // that two MDM services are not managing the same VPP account.
 type ClientContext struct {
@@ -48,29 +71,170 @@ func NewClientContext(hostname string) *ClientContext {
 
 type ConfigService interface {
 	ServiceConfig() (*ServiceConfig, error)
+	ServiceConfigWithContext(ctx context.Context) (*ServiceConfig, error)
+	RefreshServiceConfig(ctx context.Context) (*ServiceConfig, error)
 	ClientContext() (string, error)
+	ClientContextWithContext(ctx context.Context) (string, error)
 	UpdateClientContext(clientContext *ClientContext) (string, error)
+	UpdateClientContextWithContext(ctx context.Context, clientContext *ClientContext) (string, error)
 }
 
 type configService struct {
 	client *vppClient
-	sToken string
 }
 
+// ServiceConfig returns the service configuration, which is fetched from the VPP service on first use and again
+// whenever the cached copy is older than its TTL. If the configuration cannot be fetched again, the cached copy
+// continues to be used.
 func (s *configService) ServiceConfig() (*ServiceConfig, error) {
+	return s.ServiceConfigWithContext(context.Background())
+}
+
+// ServiceConfigWithContext is like ServiceConfig but carries a context for cancellation and deadlines.
+func (s *configService) ServiceConfigWithContext(ctx context.Context) (*ServiceConfig, error) {
+	return s.client.loadServiceConfig(ctx)
+}
+
+// RefreshServiceConfig fetches the service configuration from the VPP service, replacing the cached copy.
+func (s *configService) RefreshServiceConfig(ctx context.Context) (*ServiceConfig, error) {
+	serviceConfig, metrics, err := s.client.fetchServiceConfig(ctx)
+	if err == nil {
+		s.client.storeServiceConfig(serviceConfig)
+	}
+	s.client.observe(metrics)
+
+	return serviceConfig, err
+}
+
+// serviceConfigCache holds the service configuration of a client. The lock is only held to read or replace the
+// cached configuration, never while it is fetched.
+type serviceConfigCache struct {
+	mu        sync.Mutex
+	config    *ServiceConfig
+	fetchedAt time.Time
+	retryAt   time.Time
+	ttl       time.Duration
+	inflight  *serviceConfigCall
+}
+
+// serviceConfigCall is a fetch of the service configuration which other goroutines may wait for.
+type serviceConfigCall struct {
+	done   chan struct{}
+	config *ServiceConfig
+	err    error
+
+	// abandoned is set if the fetch failed because the context of the goroutine making it was done, in which case
+	// the goroutines waiting for it fetch the configuration again.
+	abandoned bool
+}
+
+// loadServiceConfig returns the cached service configuration, fetching it if it is missing or has expired.
+// Concurrent callers share a single fetch, and may give up waiting for it when their own context is done.
+// If an expired configuration cannot be fetched again, it continues to be used without another attempt for
+// serviceConfigRetryInterval, so that requests are not delayed by a fetch while the service is unavailable.
+func (c *vppClient) loadServiceConfig(ctx context.Context) (*ServiceConfig, error) {
+	cache := &c.serviceConfig
+	for {
+		cache.mu.Lock()
+		if cache.config != nil && (time.Since(cache.fetchedAt) < cache.ttl || time.Now().Before(cache.retryAt)) {
+			serviceConfig := cache.config
+			cache.mu.Unlock()
+			return serviceConfig, nil
+		}
+		call := cache.inflight
+		leading := call == nil
+		if leading {
+			call = &serviceConfigCall{done: make(chan struct{})}
+			cache.inflight = call
+		}
+		cache.mu.Unlock()
+
+		if leading {
+			c.runServiceConfigCall(ctx, call)
+		} else {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-call.done:
+			}
+			if call.abandoned {
+				continue
+			}
+		}
+
+		if call.err != nil {
+			if stale := c.cachedServiceConfig(); stale != nil {
+				return stale, nil
+			}
+			return nil, call.err
+		}
+		return call.config, nil
+	}
+}
+
+// runServiceConfigCall fetches the service configuration, stores it and hands it to the goroutines waiting for it.
+// The request is reported to Instrumentation only afterwards, so that hooks may use the configuration.
+func (c *vppClient) runServiceConfigCall(ctx context.Context, call *serviceConfigCall) {
+	serviceConfig, metrics, err := c.fetchServiceConfig(ctx)
+
+	cache := &c.serviceConfig
+	cache.mu.Lock()
+	cache.inflight = nil
+	call.config, call.err = serviceConfig, err
+	call.abandoned = err != nil && ctx.Err() != nil
+	switch {
+	case err == nil:
+		cache.config = serviceConfig
+		cache.fetchedAt = time.Now()
+		cache.retryAt = time.Time{}
+	case cache.config != nil && !call.abandoned:
+		cache.retryAt = time.Now().Add(serviceConfigRetryInterval)
+	}
+	cache.mu.Unlock()
+	close(call.done)
+
+	c.observe(metrics)
+}
+
+// storeServiceConfig replaces the cached service configuration.
+func (c *vppClient) storeServiceConfig(serviceConfig *ServiceConfig) {
+	cache := &c.serviceConfig
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.config = serviceConfig
+	cache.fetchedAt = time.Now()
+	cache.retryAt = time.Time{}
+}
+
+// cachedServiceConfig returns the cached service configuration without fetching it, or nil if it was never fetched.
+func (c *vppClient) cachedServiceConfig() *ServiceConfig {
+	cache := &c.serviceConfig
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.config
+}
+
+// fetchServiceConfig requests the service configuration, returning the metrics of the request for the caller to
+// report. The cache must not be locked by the caller.
+func (c *vppClient) fetchServiceConfig(ctx context.Context) (*ServiceConfig, RequestMetrics, error) {
 	var response ServiceConfig
 	path, _ := url.Parse(serviceConfigPath)
-	u := s.client.BaseURL.ResolveReference(path)
-	req, err := s.client.NewRequest("GET", u.String(), nil)
+	base := *c.BaseURL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	u := base.ResolveReference(path)
+	req, err := c.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, RequestMetrics{}, err
 	}
-	err = s.client.Do(req, &response)
+	metrics, err := c.send(req, &response)
 	if err != nil {
-		return nil, err
+		return nil, metrics, err
 	}
-
-	return &response, nil
+	return &response, metrics, nil
 }
 
 type vppClientConfigSrvRequest struct {
@@ -87,12 +251,27 @@ type vppClientConfigSrvResponse struct {
 
 // ClientContext retrieves the current clientContext value by posting an empty request to clientConfigSrvURL.
 func (s *configService) ClientContext() (string, error) {
+	return s.ClientContextWithContext(context.Background())
+}
+
+// ClientContextWithContext is like ClientContext but carries a context for cancellation and deadlines.
+func (s *configService) ClientContextWithContext(ctx context.Context) (string, error) {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	var response *vppClientConfigSrvResponse
 	var request *vppClientConfigSrvRequest = &vppClientConfigSrvRequest{
-		SToken: s.sToken,
+		SToken: sToken,
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.ClientConfigSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.ClientConfigSrvURL, request)
 	if err != nil {
 		return "", err
 	}
@@ -103,7 +282,7 @@ func (s *configService) ClientContext() (string, error) {
 	}
 
 	if response.Status == StatusErr {
-		return "", response.VPPError
+		return "", s.client.vppError(response.VPPError)
 	}
 
 	return response.ClientContext, nil
@@ -113,19 +292,34 @@ func (s *configService) ClientContext() (string, error) {
 // The clientContext is used to indicate which product is managing this VPP account so that two products are not
 // simultaneously attempting to associate/disassociate licenses.
 func (s *configService) UpdateClientContext(clientContext *ClientContext) (string, error) {
+	return s.UpdateClientContextWithContext(context.Background(), clientContext)
+}
+
+// UpdateClientContextWithContext is like UpdateClientContext but carries a context for cancellation and deadlines.
+func (s *configService) UpdateClientContextWithContext(ctx context.Context, clientContext *ClientContext) (string, error) {
 	var clientContextBytes []byte
 	clientContextBytes, err := json.Marshal(&clientContext)
 	if err != nil {
 		return "", err
 	}
 
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	var response *vppClientConfigSrvResponse
 	var request *vppClientConfigSrvRequest = &vppClientConfigSrvRequest{
 		ClientContext: string(clientContextBytes),
-		SToken:        s.sToken,
+		SToken:        sToken,
+	}
+
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return "", err
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.ClientConfigSrvURL, request)
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.ClientConfigSrvURL, request)
 	if err != nil {
 		return "", err
 	}
@@ -136,7 +330,7 @@ func (s *configService) UpdateClientContext(clientContext *ClientContext) (strin
 	}
 
 	if response.Status == StatusErr {
-		return "", response.VPPError
+		return "", s.client.vppError(response.VPPError)
 	}
 
 	return response.CountryCode, nil
@@ -1,8 +1,13 @@
 package vpp
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestConfigService_ServiceConfig(t *testing.T) {
@@ -52,3 +57,153 @@ func TestConfigService_UpdateClientContext(t *testing.T) {
 
 	fmt.Printf("%#v\n", countryCode)
 }
+
+// serviceConfigServer serves a service config, counting the requests made and failing them while failing is set.
+func serviceConfigServer(t *testing.T, failing *bool) (*httptest.Server, *int) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/WebObjects/MZFinance.woa/wa/VPPServiceConfigSrv" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		requests++
+		if *failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"getUsersSrvUrl":"%s/getVPPUsersSrv","maxBatchAssociateLicenseCount":%d}`, "http://"+r.Host, requests)
+	}))
+	return srv, &requests
+}
+
+func TestConfigService_ServiceConfig_Lazy(t *testing.T) {
+	failing := false
+	srv, requests := serviceConfigServer(t, &failing)
+	defer srv.Close()
+
+	client, err := NewVPPClient(&Config{}, WithBaseURL(srv.URL+"/WebObjects/MZFinance.woa/wa"), WithServiceConfigTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *requests != 0 {
+		t.Fatal("expected the service config not to be fetched by NewVPPClient")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ServiceConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if *requests != 1 {
+		t.Errorf("expected the service config to be cached, got %d requests", *requests)
+	}
+
+	refreshed, err := client.RefreshServiceConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *requests != 2 || refreshed.MaxBatchAssociateLicenseCount != 2 {
+		t.Errorf("expected refresh to fetch the service config again, got %d requests", *requests)
+	}
+
+	failing = true
+	if _, err := client.RefreshServiceConfig(context.Background()); err == nil {
+		t.Error("expected an error refreshing from a failing service")
+	}
+
+	cached, err := client.ServiceConfig()
+	if err != nil || cached != refreshed {
+		t.Errorf("expected the cached service config after a failed refresh, got %v", err)
+	}
+}
+
+func TestConfigService_ServiceConfig_Seeded(t *testing.T) {
+	failing := true
+	srv, requests := serviceConfigServer(t, &failing)
+	defer srv.Close()
+
+	seed := &ServiceConfig{GetUsersSrvURL: "http://localhost/getVPPUsersSrv"}
+	client, err := NewVPPClient(&Config{}, WithBaseURL(srv.URL+"/WebObjects/MZFinance.woa/wa/"), WithServiceConfig(seed),
+		WithServiceConfigTTL(time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		serviceConfig, err := client.ServiceConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serviceConfig != seed {
+			t.Errorf("expected an expired seed to be reused when the refresh fails")
+		}
+	}
+
+	if *requests != 1 {
+		t.Errorf("expected a single attempt to refresh the seed while the service is failing, got %d", *requests)
+	}
+}
+
+// serviceConfigHook calls ServiceConfig from an Instrumentation hook, as a hook labelling errors might.
+type serviceConfigHook struct {
+	client VPPClient
+	found  chan *ServiceConfig
+}
+
+func (h *serviceConfigHook) ObserveRequest(metrics RequestMetrics) {
+	serviceConfig, _ := h.client.ServiceConfig()
+	h.found <- serviceConfig
+}
+
+func TestConfigService_ServiceConfig_SingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-release
+		fmt.Fprintf(w, `{"getUsersSrvUrl":"%s/getVPPUsersSrv"}`, "http://"+r.Host)
+	}))
+	defer srv.Close()
+
+	hook := &serviceConfigHook{found: make(chan *ServiceConfig, 1)}
+	client, err := NewVPPClient(&Config{}, WithBaseURL(srv.URL), WithInstrumentation(hook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook.client = client
+
+	results := make(chan error, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, err := client.ServiceConfig()
+			results <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// A caller waiting for the fetch can give up through its own context.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.ServiceConfigWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the waiting caller to time out, got %v", err)
+	}
+
+	close(release)
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+	if serviceConfig := <-hook.found; serviceConfig == nil {
+		t.Error("expected the hook to find the fetched service config")
+	}
+	if requests != 1 {
+		t.Errorf("expected concurrent callers to share a single fetch, got %d requests", requests)
+	}
+}
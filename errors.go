@@ -0,0 +1,156 @@
+package vpp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error numbers returned by the VPP service.
+// The full list, along with a description of each error, is returned in ServiceConfig.ErrorCodes.
+const (
+	ErrorNumberMissingArgument        = 9600
+	ErrorNumberLoginRequired          = 9601
+	ErrorNumberInvalidArgument        = 9602
+	ErrorNumberInternalError          = 9603
+	ErrorNumberResultNotFound         = 9604
+	ErrorNumberLicenseIrrevocable     = 9607
+	ErrorNumberUserNotFound           = 9609
+	ErrorNumberLicenseNotFound        = 9610
+	ErrorNumberLicenseAlreadyAssigned = 9616
+	ErrorNumberUserAlreadyRetired     = 9618
+	ErrorNumberLicenseNotAssociated   = 9619
+	ErrorNumberUserAlreadyDeleted     = 9620
+	ErrorNumberSTokenExpired          = 9621
+	ErrorNumberInvalidSToken          = 9622
+	ErrorNumberSTokenRevoked          = 9625
+)
+
+// Sentinel errors for use with errors.Is. A *VPPError matches a sentinel if it has the same error number.
+var (
+	ErrMissingArgument        = &VPPError{ErrorNumber: ErrorNumberMissingArgument, ErrorMessage: "Missing argument"}
+	ErrLoginRequired          = &VPPError{ErrorNumber: ErrorNumberLoginRequired, ErrorMessage: "Login required"}
+	ErrInvalidArgument        = &VPPError{ErrorNumber: ErrorNumberInvalidArgument, ErrorMessage: "Invalid argument"}
+	ErrInternalError          = &VPPError{ErrorNumber: ErrorNumberInternalError, ErrorMessage: "Internal error"}
+	ErrResultNotFound         = &VPPError{ErrorNumber: ErrorNumberResultNotFound, ErrorMessage: "Result not found"}
+	ErrLicenseIrrevocable     = &VPPError{ErrorNumber: ErrorNumberLicenseIrrevocable, ErrorMessage: "License is irrevocable"}
+	ErrUserNotFound           = &VPPError{ErrorNumber: ErrorNumberUserNotFound, ErrorMessage: "Registered user not found"}
+	ErrLicenseNotFound        = &VPPError{ErrorNumber: ErrorNumberLicenseNotFound, ErrorMessage: "License not found"}
+	ErrLicenseAlreadyAssigned = &VPPError{ErrorNumber: ErrorNumberLicenseAlreadyAssigned, ErrorMessage: "License already assigned"}
+	ErrUserAlreadyRetired     = &VPPError{ErrorNumber: ErrorNumberUserAlreadyRetired, ErrorMessage: "User already retired"}
+	ErrLicenseNotAssociated   = &VPPError{ErrorNumber: ErrorNumberLicenseNotAssociated, ErrorMessage: "License not associated"}
+	ErrUserAlreadyDeleted     = &VPPError{ErrorNumber: ErrorNumberUserAlreadyDeleted, ErrorMessage: "User already deleted"}
+	ErrSTokenExpired          = &VPPError{ErrorNumber: ErrorNumberSTokenExpired, ErrorMessage: "sToken has expired"}
+	ErrInvalidSToken          = &VPPError{ErrorNumber: ErrorNumberInvalidSToken, ErrorMessage: "Invalid sToken"}
+	ErrSTokenRevoked          = &VPPError{ErrorNumber: ErrorNumberSTokenRevoked, ErrorMessage: "sToken has been revoked"}
+)
+
+// VPPError is an error reported by the VPP service in the body of a response.
+type VPPError struct {
+	ErrorMessage string `json:"errorMessage"`
+	ErrorNumber  int    `json:"errorNumber"`
+}
+
+func (e *VPPError) Error() string {
+	return fmt.Sprintf("(%d) %s", e.ErrorNumber, e.ErrorMessage)
+}
+
+// Is reports whether the target is a *VPPError with the same error number, so that errors.Is can be used with the
+// sentinel errors of this package.
+func (e *VPPError) Is(target error) bool {
+	t, ok := target.(*VPPError)
+	return ok && t.ErrorNumber == e.ErrorNumber
+}
+
+// HTTPError is returned when the VPP service responds with an unexpected HTTP status.
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("VPP API Error: HTTP %d: %s", e.StatusCode, string(e.Body))
+}
+
+// ServiceBusyError is returned when the VPP service keeps asking the client to back off via Retry-After and the
+// retry budget has been exhausted, or the next retry would be too late.
+type ServiceBusyError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Retries    int
+
+	// Err is set if the client gave up before the budget was exhausted, eg. context.DeadlineExceeded when the delay
+	// would pass the deadline of the request context.
+	Err error
+}
+
+func (e *ServiceBusyError) Error() string {
+	msg := fmt.Sprintf("VPP service busy (HTTP %d), retry after %s (gave up after %d retries)", e.StatusCode, e.RetryAfter, e.Retries)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ServiceBusyError) Unwrap() error {
+	return e.Err
+}
+
+// IsUserNotFound reports whether the error indicates that the VPP user does not exist.
+func IsUserNotFound(err error) bool {
+	return errors.Is(err, ErrUserNotFound)
+}
+
+// IsInvalidSToken reports whether the error indicates that the sToken is invalid, expired or revoked.
+// A new token must be downloaded from the VPP portal before the client can be used again.
+func IsInvalidSToken(err error) bool {
+	return errors.Is(err, ErrInvalidSToken) || errors.Is(err, ErrSTokenExpired) || errors.Is(err, ErrSTokenRevoked)
+}
+
+// IsLicenseAlreadyAssigned reports whether the error indicates that the license is already assigned.
+func IsLicenseAlreadyAssigned(err error) bool {
+	return errors.Is(err, ErrLicenseAlreadyAssigned)
+}
+
+// IsRetryable reports whether the request that caused the error may succeed if it is sent again later.
+func IsRetryable(err error) bool {
+	var busy *ServiceBusyError
+	if errors.As(err, &busy) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+
+	return errors.Is(err, ErrInternalError)
+}
+
+// vppError converts the error embedded in a response into an error value.
+func (c *vppClient) vppError(e *VPPError) error {
+	if e == nil {
+		return &VPPError{ErrorMessage: "the VPP service returned an error status without an error"}
+	}
+
+	c.describeError(e)
+	return e
+}
+
+// describeError fills in a missing error message from the error codes listed in the service configuration.
+func (c *vppClient) describeError(e *VPPError) {
+	if e == nil || e.ErrorMessage != "" {
+		return
+	}
+
+	serviceConfig := c.cachedServiceConfig()
+	if serviceConfig == nil {
+		return
+	}
+
+	if known, ok := serviceConfig.LookupError(e.ErrorNumber); ok {
+		e.ErrorMessage = known.ErrorMessage
+	}
+}
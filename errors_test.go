@@ -0,0 +1,83 @@
+package vpp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVPPError_Is(t *testing.T) {
+	var err error = &VPPError{ErrorNumber: ErrorNumberUserNotFound, ErrorMessage: "Registered user not found"}
+	wrapped := fmt.Errorf("looking up user: %w", err)
+
+	if !errors.Is(wrapped, ErrUserNotFound) || !IsUserNotFound(wrapped) {
+		t.Error("expected error to match ErrUserNotFound")
+	}
+
+	if errors.Is(wrapped, ErrLicenseNotFound) {
+		t.Error("expected error not to match ErrLicenseNotFound")
+	}
+
+	if !IsInvalidSToken(&VPPError{ErrorNumber: ErrorNumberSTokenRevoked}) {
+		t.Error("expected a revoked sToken to be an invalid sToken")
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&ServiceBusyError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&HTTPError{StatusCode: http.StatusBadGateway}, true},
+		{&HTTPError{StatusCode: http.StatusBadRequest}, false},
+		{&VPPError{ErrorNumber: ErrorNumberInternalError}, true},
+		{&VPPError{ErrorNumber: ErrorNumberLicenseAlreadyAssigned}, false},
+		{errors.New("other"), false},
+	}
+
+	for _, tt := range tests {
+		if IsRetryable(tt.err) != tt.retryable {
+			t.Errorf("IsRetryable(%v) expected %v", tt.err, tt.retryable)
+		}
+	}
+}
+
+func TestVppClient_ErrorResponses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/getVPPUsersSrv":
+			w.Write([]byte(`{"status":-1,"errorNumber":9622}`))
+		default:
+			w.Header().Set("X-Request-Id", "abc")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad request"))
+		}
+	}))
+	defer srv.Close()
+
+	client := newTestClient(srv)
+	client.serviceConfig.config.ErrorCodes = []VPPError{{ErrorNumber: 9622, ErrorMessage: "Invalid authentication token"}}
+
+	_, err := client.GetUsers(&BatchRequest{})
+	var vppErr *VPPError
+	if !errors.As(err, &vppErr) || !IsInvalidSToken(err) {
+		t.Fatalf("expected an invalid sToken error, got %#v", err)
+	}
+
+	if vppErr.ErrorMessage != "Invalid authentication token" {
+		t.Errorf("expected the message to be filled in from the service config, got %q", vppErr.ErrorMessage)
+	}
+
+	_, err = client.GetLicenses(&BatchRequest{})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected *HTTPError, got %#v", err)
+	}
+
+	if httpErr.StatusCode != http.StatusBadRequest || httpErr.Header.Get("X-Request-Id") != "abc" || string(httpErr.Body) != "bad request" {
+		t.Errorf("unexpected HTTP error: %#v", httpErr)
+	}
+}
@@ -0,0 +1,47 @@
+// Package redact replaces the values of sensitive fields in the JSON bodies of VPP service requests and responses,
+// such as the sToken and the email addresses and invitations of users.
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Redacted replaces the values of sensitive fields.
+const Redacted = "REDACTED"
+
+// fields lists the sensitive fields of request and response bodies, compared case-insensitively.
+var fields = map[string]bool{
+	"stoken":     true,
+	"email":      true,
+	"invitecode": true,
+	"inviteurl":  true,
+}
+
+// JSON returns a JSON body with the values of sensitive fields replaced at any depth.
+// An error is returned if the body is not valid JSON, in which case it cannot be redacted.
+func JSON(body []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactValue(value))
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if fields[strings.ToLower(key)] {
+				v[key] = Redacted
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
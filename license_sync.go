@@ -0,0 +1,242 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultLicenseCheckpointKey is the key under which a LicenseSyncer saves its checkpoint unless another is given.
+const DefaultLicenseCheckpointKey = "licenses"
+
+// LicenseEventType describes how licenses changed since the last sync.
+type LicenseEventType int
+
+const (
+	LicenseAssigned LicenseEventType = iota + 1
+	LicenseFreed
+	LicensesAdded
+)
+
+func (t LicenseEventType) String() string {
+	switch t {
+	case LicenseAssigned:
+		return "assigned"
+	case LicenseFreed:
+		return "freed"
+	case LicensesAdded:
+		return "added"
+	default:
+		return fmt.Sprintf("LicenseEventType(%d)", int(t))
+	}
+}
+
+// LicenseEvent reports licenses which were assigned, freed or purchased since the last sync.
+type LicenseEvent struct {
+	Type LicenseEventType
+
+	// Licenses holds the license which was assigned or freed, or every license of a single asset which was added.
+	Licenses []VPPLicense
+
+	// Previous is the license as it was before it was freed, including the user or device it was assigned to.
+	// It is nil if the syncer was restarted since the license was last seen.
+	Previous *VPPLicense
+}
+
+// LicenseSyncer keeps a local view of every license of a VPP account, and reports the changes it finds on each sync.
+//
+// The first sync walks every license and reports them all as added, grouped by asset, so that downstream systems can
+// be seeded, and saves the sinceModifiedToken returned by the VPP service to a CheckpointStore. Later syncs request
+// only the licenses modified since the checkpoint. Licenses which are reassigned are reported as freed and then
+// assigned, and licenses which are purchased and assigned between syncs are reported as added and then assigned.
+//
+// The local view is kept in memory. After a restart it holds only the licenses modified since the checkpoint, and the
+// syncer cannot tell whether a license it has not seen is new, so it reports it as assigned or as freed without the
+// previous assignment. Reset walks every license again.
+//
+// Events are delivered at least once: if the handler returns an error, the view and the checkpoint are left as they
+// were and the next sync reports the same changes again.
+type LicenseSyncer struct {
+	service LicensesService
+	store   CheckpointStore
+	key     string
+	opts    []GetLicensesOption
+
+	syncMu sync.Mutex // held by Sync and Reset
+
+	mu       sync.Mutex
+	licenses map[string]VPPLicense
+	seeded   bool // whether licenses holds every license, after a full sync by this syncer
+}
+
+// NewLicenseSyncer creates a syncer for the licenses of the given service, saving checkpoints to the store under the
+// key, or DefaultLicenseCheckpointKey if the key is empty. Options may be given to sync only some licenses, eg.
+// ForAdamID, in which case each set of options should have its own key.
+func NewLicenseSyncer(service LicensesService, store CheckpointStore, key string, opts ...GetLicensesOption) *LicenseSyncer {
+	if key == "" {
+		key = DefaultLicenseCheckpointKey
+	}
+	return &LicenseSyncer{service: service, store: store, key: key, opts: opts, licenses: make(map[string]VPPLicense)}
+}
+
+// Sync requests the licenses modified since the last sync, reporting the changes to the handler and updating the
+// local view. The handler may read the view, which is updated once every event was handled, but must not call Sync
+// or Reset.
+func (s *LicenseSyncer) Sync(ctx context.Context, handle func(LicenseEvent) error) error {
+	if handle == nil {
+		return errors.New("no event handler given")
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	token, err := s.store.LoadCheckpoint(s.key)
+	if err != nil {
+		return err
+	}
+	full := token == ""
+
+	var modified []VPPLicense
+	it := s.service.IterateLicenses(ctx, &BatchRequest{SinceModifiedToken: token}, s.opts...)
+	for it.Next() {
+		modified = append(modified, it.License())
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	next := it.SinceModifiedToken()
+	if next == "" {
+		return errors.New("the VPP service did not return a sinceModifiedToken")
+	}
+
+	for _, event := range s.events(modified, full) {
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+
+	if err := s.store.SaveCheckpoint(s.key, next); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if full {
+		s.licenses = make(map[string]VPPLicense)
+		s.seeded = true
+	}
+	for _, license := range modified {
+		s.licenses[license.LicenseID] = license
+	}
+	return nil
+}
+
+// events compares the modified licenses with the local view. New licenses are reported first, grouped by asset,
+// followed by assignments and unassignments in the order they were received.
+func (s *LicenseSyncer) events(modified []VPPLicense, full bool) []LicenseEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var added []LicenseEvent
+	addedByAsset := make(map[string]int)
+	var changed []LicenseEvent
+
+	for i := range modified {
+		license := modified[i]
+		previous, known := s.licenses[license.LicenseID]
+		if full {
+			known = false
+		}
+
+		if !known {
+			switch {
+			case full || s.seeded:
+				key := licenseAssetKey(&license)
+				if index, ok := addedByAsset[key]; ok {
+					added[index].Licenses = append(added[index].Licenses, license)
+				} else {
+					addedByAsset[key] = len(added)
+					added = append(added, LicenseEvent{Type: LicensesAdded, Licenses: []VPPLicense{license}})
+				}
+				if !full && license.Assigned() {
+					changed = append(changed, LicenseEvent{Type: LicenseAssigned, Licenses: []VPPLicense{license}})
+				}
+			case license.Assigned():
+				// After a restart it is not known whether the license is new, or who held it before.
+				changed = append(changed, LicenseEvent{Type: LicenseAssigned, Licenses: []VPPLicense{license}})
+			default:
+				changed = append(changed, LicenseEvent{Type: LicenseFreed, Licenses: []VPPLicense{license}})
+			}
+			continue
+		}
+
+		if previous.assignee() == license.assignee() {
+			continue
+		}
+		if previous.Assigned() {
+			previous := previous
+			changed = append(changed, LicenseEvent{Type: LicenseFreed, Licenses: []VPPLicense{license}, Previous: &previous})
+		}
+		if license.Assigned() {
+			changed = append(changed, LicenseEvent{Type: LicenseAssigned, Licenses: []VPPLicense{license}})
+		}
+	}
+
+	return append(added, changed...)
+}
+
+// Reset discards the checkpoint and the local view, so that the next sync walks every license again.
+func (s *LicenseSyncer) Reset() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if err := s.store.SaveCheckpoint(s.key, ""); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.licenses = make(map[string]VPPLicense)
+	s.seeded = false
+	return nil
+}
+
+func licenseAssetKey(license *VPPLicense) string {
+	if license.VPPAsset == nil {
+		return ""
+	}
+	return license.AdamID + "/" + string(license.PricingParam)
+}
+
+// Licenses returns the licenses in the local view, ordered by license id.
+func (s *LicenseSyncer) Licenses() []VPPLicense {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	licenses := make([]VPPLicense, 0, len(s.licenses))
+	for _, license := range s.licenses {
+		licenses = append(licenses, license)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		a, b := licenses[i].LicenseID, licenses[j].LicenseID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return licenses
+}
+
+// License returns a license in the local view.
+func (s *LicenseSyncer) License(licenseID string) (VPPLicense, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	license, ok := s.licenses[licenseID]
+	return license, ok
+}
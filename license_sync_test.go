@@ -0,0 +1,170 @@
+package vpp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mosen/vpp/vpptest"
+)
+
+func collectLicenseEvents(t *testing.T, syncer *LicenseSyncer) []LicenseEvent {
+	var events []LicenseEvent
+	err := syncer.Sync(context.Background(), func(event LicenseEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestLicenseSyncer_Sync(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer := NewLicenseSyncer(vppClient, NewMemoryCheckpointStore(), "")
+
+	// The first sync reports every license as added.
+	events := collectLicenseEvents(t, syncer)
+	if len(events) != 1 || events[0].Type != LicensesAdded || len(events[0].Licenses) != 10 {
+		t.Fatalf("expected the 10 licenses of the fixture to be added, got %#v", events)
+	}
+	if licenses := syncer.Licenses(); len(licenses) != 10 || licenses[9].LicenseID != "10" {
+		t.Errorf("expected the view to hold every license in order, got %v", licenses)
+	}
+
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726"}, 2)
+	devices := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	devices.AssignSerialNumber("C02AAAAAAAAA")
+	users := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	users.AssignUser(&VPPUser{ClientUserIdStr: clientIdStrFixture})
+	for _, ops := range []*LicenseOperations{devices, users} {
+		if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events = collectLicenseEvents(t, syncer)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %#v", events)
+	}
+	if events[0].Type != LicensesAdded || len(events[0].Licenses) != 2 || events[0].Licenses[0].AdamID != "361309726" {
+		t.Errorf("expected the new licenses to be added first, got %#v", events[0])
+	}
+	for _, event := range events[1:] {
+		if event.Type != LicenseAssigned || !event.Licenses[0].Assigned() {
+			t.Errorf("expected an assignment, got %#v", event)
+		}
+	}
+
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	ops.UnassignSerialNumber("C02AAAAAAAAA")
+	if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+		t.Fatal(err)
+	}
+
+	events = collectLicenseEvents(t, syncer)
+	if len(events) != 1 || events[0].Type != LicenseFreed || events[0].Previous.SerialNumber != "C02AAAAAAAAA" {
+		t.Fatalf("expected the license to be freed from the device, got %#v", events)
+	}
+	if license, _ := syncer.License(events[0].Licenses[0].LicenseID); license.Assigned() {
+		t.Errorf("expected the view to be updated, got %#v", license)
+	}
+
+	if events = collectLicenseEvents(t, syncer); len(events) != 0 {
+		t.Errorf("expected no events without modifications, got %#v", events)
+	}
+}
+
+func TestLicenseSyncer_HandlerError(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer := NewLicenseSyncer(vppClient, NewMemoryCheckpointStore(), "", ForAdamID("408709785"))
+
+	err = syncer.Sync(context.Background(), func(event LicenseEvent) error {
+		return context.Canceled
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if licenses := syncer.Licenses(); len(licenses) != 0 {
+		t.Errorf("expected the view not to be updated, got %v", licenses)
+	}
+
+	if events := collectLicenseEvents(t, syncer); len(events) != 1 || len(events[0].Licenses) != 10 {
+		t.Errorf("expected the failed sync to be repeated, got %#v", events)
+	}
+}
+
+func TestLicenseSyncer_AddedAndAssigned(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncer := NewLicenseSyncer(vppClient, NewMemoryCheckpointStore(), "")
+	collectLicenseEvents(t, syncer)
+
+	// Licenses purchased and assigned between syncs are reported as added and then assigned.
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726", DeviceAssignable: true}, 2)
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "361309726"})
+	ops.AssignSerialNumber("C02AAAAAAAAA")
+	if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+		t.Fatal(err)
+	}
+
+	events := collectLicenseEvents(t, syncer)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %#v", events)
+	}
+	if events[0].Type != LicensesAdded || len(events[0].Licenses) != 2 {
+		t.Errorf("expected the purchased licenses to be added, got %#v", events[0])
+	}
+	if events[1].Type != LicenseAssigned || events[1].Licenses[0].SerialNumber != "C02AAAAAAAAA" {
+		t.Errorf("expected the purchased license to be assigned, got %#v", events[1])
+	}
+}
+
+func TestLicenseSyncer_Restart(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryCheckpointStore()
+	collectLicenseEvents(t, NewLicenseSyncer(vppClient, store, ""))
+
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	ops.AssignSerialNumber("C02AAAAAAAAA")
+	if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// A syncer using the same store continues from the checkpoint rather than reporting every license as added.
+	restarted := NewLicenseSyncer(vppClient, store, "")
+	events := collectLicenseEvents(t, restarted)
+	if len(events) != 1 || events[0].Type != LicenseAssigned || events[0].Licenses[0].SerialNumber != "C02AAAAAAAAA" {
+		t.Fatalf("expected only the assignment since the checkpoint, got %#v", events)
+	}
+
+	if err := restarted.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if events := collectLicenseEvents(t, restarted); len(events) != 1 || len(events[0].Licenses) != 10 {
+		t.Errorf("expected every license to be added after a reset, got %#v", events)
+	}
+}
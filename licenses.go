@@ -1,15 +1,43 @@
 package vpp
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
 
 // VPPLicense describes a licensed product (VPPAsset) and its (optional) association with a VPP User.
 type VPPLicense struct {
 	LicenseID     string `json:"licenseIdStr,omitempty"`
 	IsIrrevocable bool   `json:"isIrrevocable"`
+	SerialNumber  string `json:"serialNumber,omitempty"` // set if the license is assigned to a device
 	*VPPAsset
 	*VPPUser
 }
 
+// Assigned reports whether the license is assigned to a user or a device.
+func (l *VPPLicense) Assigned() bool {
+	return l.assignee() != ""
+}
+
+// assignee identifies the user or device that the license is assigned to, or is empty if it is available.
+func (l *VPPLicense) assignee() string {
+	switch {
+	case l.SerialNumber != "":
+		return "serialNumber:" + l.SerialNumber
+	case l.VPPUser == nil:
+		return ""
+	case l.VPPUser.ClientUserIdStr != "":
+		return "clientUserIdStr:" + l.VPPUser.ClientUserIdStr
+	case l.VPPUser.UserID != 0:
+		return "userId:" + strconv.Itoa(l.VPPUser.UserID)
+	default:
+		return ""
+	}
+}
+
 // LicenseAssociation describes an association between a (VPP user OR device serial) and a license
 type LicenseAssociation struct {
 	ClientUserIDStr string `json:"clientUserIdStr,omitempty"`
@@ -18,6 +46,11 @@ type LicenseAssociation struct {
 	*VPPError
 }
 
+// Failed reports whether the VPP service rejected this association or disassociation.
+func (a LicenseAssociation) Failed() bool {
+	return a.VPPError != nil
+}
+
 type getVPPLicensesSrvRequest struct {
 	*getLicensesRequestOpts
 	*BatchRequest
@@ -30,45 +63,133 @@ type getVPPLicensesSrvResponse struct {
 	TotalBatchCount int          `json:"totalBatchCount,omitempty"`
 	Licenses        []VPPLicense `json:"licenses,omitempty"`
 	*VPPError
+	BatchToken         string `json:"batchToken,omitempty"`
+	SinceModifiedToken string `json:"sinceModifiedToken,omitempty"`
 }
 
 // LicensesService describes an interface that can manage VPP licenses
 type LicensesService interface {
 	GetLicenses(batch *BatchRequest, opts ...GetLicensesOption) ([]VPPLicense, error)
+	GetLicensesWithContext(ctx context.Context, batch *BatchRequest, opts ...GetLicensesOption) ([]VPPLicense, error)
 	AssociateLicense(user *VPPUser, license *VPPLicense) error
+	AssociateLicenseWithContext(ctx context.Context, user *VPPUser, license *VPPLicense) error
 	DisassociateLicense(user *VPPUser, license *VPPLicense) error
+	DisassociateLicenseWithContext(ctx context.Context, user *VPPUser, license *VPPLicense) error
+	IterateLicenses(ctx context.Context, batch *BatchRequest, opts ...GetLicensesOption) *LicensesIterator
+	ManageLicenses(operations *LicenseOperations, notify bool) (*ManageLicensesResult, error)
+	ManageLicensesWithContext(ctx context.Context, operations *LicenseOperations, notify bool) (*ManageLicensesResult, error)
 }
 
 type licensesService struct {
 	client *vppClient
-	sToken string
 }
 
 type getLicensesRequestOpts struct {
-	AssignedOnly bool         `json:"assignedOnly,omitempty"`
-	AdamID       int          `json:"adamId,omitempty"`
-	PricingParam PricingParam `json:"pricingParam,omitempty"`
+	AssignedOnly    bool         `json:"assignedOnly,omitempty"`
+	AdamID          int          `json:"adamId,omitempty"`
+	PricingParam    PricingParam `json:"pricingParam,omitempty"`
+	SerialNumber    string       `json:"serialNumber,omitempty"`
+	ClientUserIdStr string       `json:"clientUserIdStr,omitempty"`
 }
 
 // GetLicensesOption describes the signature of the closure returned by a function adding an argument to GetLicenses
 type GetLicensesOption func(*getLicensesRequestOpts) error
 
+// AssignedOnly is an argument given to GetLicenses to only return licenses that are assigned to a user or device.
+func AssignedOnly() GetLicensesOption {
+	return func(opts *getLicensesRequestOpts) error {
+		opts.AssignedOnly = true
+		return nil
+	}
+}
+
+// ForAdamID is an argument given to GetLicenses to only return licenses for the given app or book.
+func ForAdamID(adamID string) GetLicensesOption {
+	return func(opts *getLicensesRequestOpts) error {
+		if adamID == "" {
+			return errors.New("no adam id given")
+		}
+		id, err := strconv.Atoi(adamID)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("adam id %q is not a positive number", adamID)
+		}
+		opts.AdamID = id
+		return nil
+	}
+}
+
+// WithPricingParam is an argument given to GetLicenses to only return licenses of the given quality.
+func WithPricingParam(pricingParam PricingParam) GetLicensesOption {
+	return func(opts *getLicensesRequestOpts) error {
+		if pricingParam != PricingParamStd && pricingParam != PricingParamPlus {
+			return fmt.Errorf("unknown pricing param %q", pricingParam)
+		}
+		opts.PricingParam = pricingParam
+		return nil
+	}
+}
+
+// ForSerialNumber is an argument given to GetLicenses to only return licenses assigned to the given device.
+func ForSerialNumber(serialNumber string) GetLicensesOption {
+	return func(opts *getLicensesRequestOpts) error {
+		if serialNumber == "" {
+			return errors.New("no serial number given")
+		}
+		if opts.ClientUserIdStr != "" {
+			return errors.New("licenses can be filtered by either serial number or client user id, not both")
+		}
+		opts.SerialNumber = serialNumber
+		return nil
+	}
+}
+
+// ForClientUserID is an argument given to GetLicenses to only return licenses assigned to the given user.
+func ForClientUserID(clientUserIdStr string) GetLicensesOption {
+	return func(opts *getLicensesRequestOpts) error {
+		if clientUserIdStr == "" {
+			return errors.New("no client user id given")
+		}
+		if opts.SerialNumber != "" {
+			return errors.New("licenses can be filtered by either serial number or client user id, not both")
+		}
+		opts.ClientUserIdStr = clientUserIdStr
+		return nil
+	}
+}
+
 // GetLicenses retrieves a list of available VPP licenses. The result can optionally be filtered by the application id
 // and/or its assigned status.
+// Licenses are returned in batches. The batch given is updated with the tokens and totals of the response, so that it
+// can be passed to GetLicenses again while batch.HasNext() is true.
 func (s *licensesService) GetLicenses(batch *BatchRequest, opts ...GetLicensesOption) ([]VPPLicense, error) {
+	return s.GetLicensesWithContext(context.Background(), batch, opts...)
+}
+
+// GetLicensesWithContext is like GetLicenses but carries a context for cancellation and deadlines.
+func (s *licensesService) GetLicensesWithContext(ctx context.Context, batch *BatchRequest, opts ...GetLicensesOption) ([]VPPLicense, error) {
 	requestOpts := &getLicensesRequestOpts{}
 	for _, option := range opts {
 		if err := option(requestOpts); err != nil {
 			return nil, err
 		}
 	}
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var request *getVPPLicensesSrvRequest = &getVPPLicensesSrvRequest{
 		getLicensesRequestOpts: requestOpts,
-		SToken:                 s.sToken,
+		SToken:                 sToken,
 		BatchRequest:           batch,
 	}
 	var response getVPPLicensesSrvResponse
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.GetLicensesSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.GetLicensesSrvURL, request)
 	if err != nil {
 		return nil, err
 	}
@@ -77,21 +198,79 @@ func (s *licensesService) GetLicenses(batch *BatchRequest, opts ...GetLicensesOp
 		return nil, err
 	}
 	if response.Status == StatusErr {
-		return nil, response.VPPError
+		return nil, s.client.vppError(response.VPPError)
+	}
+
+	if batch != nil {
+		batch.update(response.BatchToken, response.SinceModifiedToken, response.TotalCount, response.TotalBatchCount)
 	}
+
 	return response.Licenses, nil
 }
 
+// LicensesIterator walks every license returned by GetLicenses, requesting each batch as the previous one is exhausted.
+type LicensesIterator struct {
+	batchIterator
+	service LicensesService
+	opts    []GetLicensesOption
+
+	licenses []VPPLicense
+	current  VPPLicense
+}
+
+// NewLicensesIterator creates an iterator over the licenses of the given service.
+// Pass a batch containing a SinceModifiedToken to iterate only over licenses that changed since that token was issued.
+// The batch given is not modified.
+func NewLicensesIterator(ctx context.Context, service LicensesService, batch *BatchRequest, opts ...GetLicensesOption) *LicensesIterator {
+	return &LicensesIterator{
+		batchIterator: newBatchIterator(ctx, batch),
+		service:       service,
+		opts:          opts,
+	}
+}
+
+// IterateLicenses returns an iterator over every license, following batch tokens automatically.
+func (s *licensesService) IterateLicenses(ctx context.Context, batch *BatchRequest, opts ...GetLicensesOption) *LicensesIterator {
+	return NewLicensesIterator(ctx, s, batch, opts...)
+}
+
+// Next advances the iterator to the next license, fetching the next batch if required.
+// It returns false when there are no more licenses, or when an error occurred.
+func (it *LicensesIterator) Next() bool {
+	if !it.next(it.fetch) {
+		return false
+	}
+
+	it.current, it.licenses = it.licenses[0], it.licenses[1:]
+	return true
+}
+
+func (it *LicensesIterator) fetch(ctx context.Context, batch *BatchRequest) (int, error) {
+	licenses, err := it.service.GetLicensesWithContext(ctx, batch, it.opts...)
+	it.licenses = licenses
+	return len(licenses), err
+}
+
+// License returns the license that the iterator is currently positioned at.
+func (it *LicensesIterator) License() VPPLicense {
+	return it.current
+}
+
+// TotalBatchCount returns the number of batches reported by the VPP service, if known.
+func (it *LicensesIterator) TotalBatchCount() int {
+	return it.batch.TotalBatchCount
+}
+
 type manageVPPLicensesByAdamIdSrvRequest struct {
 	AdamIDStr    string       `json:"adamIdStr"`
 	PricingParam PricingParam `json:"pricingParam"`
 
 	// Only one of the below is required
-	AssociateClientIDStrs  []string `json:"associateClientIdStrs,omitempty"`
-	AssociateSerialNumbers []string `json:"associateSerialNumbers,omitempty"`
+	AssociateClientUserIDStrs []string `json:"associateClientUserIdStrs,omitempty"`
+	AssociateSerialNumbers    []string `json:"associateSerialNumbers,omitempty"`
 
 	// Only one of the below is required
-	DisassociateClientUserIDStrs []string `json:"disassociateClientIdStrs,omitempty"`
+	DisassociateClientUserIDStrs []string `json:"disassociateClientUserIdStrs,omitempty"`
 	DisassociateLicenseIDStrs    []string `json:"disassociateLicenseIdStrs,omitempty"`
 	DisassociateSerialNumbers    []string `json:"disassociateSerialNumbers,omitempty"`
 
@@ -100,9 +279,16 @@ type manageVPPLicensesByAdamIdSrvRequest struct {
 }
 
 type manageVPPLicensesByAdamIdSrvResponse struct {
-	Status          Status               `json:"status"`
+	Status Status `json:"status"`
+	ManageLicensesResult
+	*VPPError
+}
+
+// ManageLicensesResult describes the outcome of a ManageLicenses call.
+// Each association and disassociation carries its own VPPError if the VPP service rejected that item.
+type ManageLicensesResult struct {
 	AdamIDStr       string               `json:"adamIdStr"`
-	ProductTypeID   int                  `json:"productTypeId"`
+	ProductTypeID   ProductType          `json:"productTypeId"`
 	PricingParam    PricingParam         `json:"pricingParam"`
 	ProductTypeName string               `json:"productTypeName"`
 	IsIrrevocable   bool                 `json:"isIrrevocable"`
@@ -110,8 +296,24 @@ type manageVPPLicensesByAdamIdSrvResponse struct {
 	Disassociations []LicenseAssociation `json:"disassociations,omitempty"`
 }
 
-// licenseOperations describes a list of operations on a single asset (app or book).
-type licenseOperations struct {
+// Failed returns every association and disassociation that the VPP service rejected.
+func (r *ManageLicensesResult) Failed() []LicenseAssociation {
+	var failed []LicenseAssociation
+	for _, association := range r.Associations {
+		if association.Failed() {
+			failed = append(failed, association)
+		}
+	}
+	for _, disassociation := range r.Disassociations {
+		if disassociation.Failed() {
+			failed = append(failed, disassociation)
+		}
+	}
+	return failed
+}
+
+// LicenseOperations describes a list of operations on a single asset (app or book).
+type LicenseOperations struct {
 	Asset *VPPAsset
 
 	AssociateUsers         []*VPPUser
@@ -123,14 +325,14 @@ type licenseOperations struct {
 }
 
 // NewLicenseOperations creates a new batch of license operations (assigning/freeing)
-func (op *licenseOperations) NewLicenseOperations(asset *VPPAsset) *licenseOperations {
-	return &licenseOperations{
+func NewLicenseOperations(asset *VPPAsset) *LicenseOperations {
+	return &LicenseOperations{
 		Asset: asset,
 	}
 }
 
 // AssignUser adds a VPP user to the list of license holders for the current asset.
-func (op *licenseOperations) AssignUser(user *VPPUser) error {
+func (op *LicenseOperations) AssignUser(user *VPPUser) error {
 	if len(op.AssociateSerialNumbers) > 0 {
 		return errors.New("you cannot assign licenses to both users and devices in the same license operation")
 	}
@@ -140,7 +342,7 @@ func (op *licenseOperations) AssignUser(user *VPPUser) error {
 }
 
 // AssignSerialNumber adds a device to the list of license holders for the current asset.
-func (op *licenseOperations) AssignSerialNumber(serialNumber string) error {
+func (op *LicenseOperations) AssignSerialNumber(serialNumber string) error {
 	if len(op.AssociateUsers) > 0 {
 		return errors.New("you cannot assign licenses to both users and devices in the same license operation")
 	}
@@ -150,7 +352,7 @@ func (op *licenseOperations) AssignSerialNumber(serialNumber string) error {
 }
 
 // UnassignUser removes a VPP user from the list of license holders for the current asset.
-func (op *licenseOperations) UnassignUser(user *VPPUser) error {
+func (op *LicenseOperations) UnassignUser(user *VPPUser) error {
 	if len(op.DisassociateSerialNumbers) > 0 || len(op.DisassociateLicenseIDs) > 0 {
 		return errors.New("you can only unassign licenses from either users, serial numbers, or license ids in a single operation")
 	}
@@ -160,7 +362,7 @@ func (op *licenseOperations) UnassignUser(user *VPPUser) error {
 }
 
 // UnassignSerialNumber removes a device from the list of license holders for the current asset.
-func (op *licenseOperations) UnassignSerialNumber(serialNumber string) error {
+func (op *LicenseOperations) UnassignSerialNumber(serialNumber string) error {
 	if len(op.DisassociateUsers) > 0 || len(op.DisassociateLicenseIDs) > 0 {
 		return errors.New("you can only unassign licenses from either users, serial numbers, or license ids in a single operation")
 	}
@@ -170,7 +372,7 @@ func (op *licenseOperations) UnassignSerialNumber(serialNumber string) error {
 }
 
 // UnassignLicenseID removes a VPP license by its identifier.
-func (op *licenseOperations) UnassignLicenseID(licenseID string) error {
+func (op *LicenseOperations) UnassignLicenseID(licenseID string) error {
 	if len(op.DisassociateUsers) > 0 || len(op.DisassociateSerialNumbers) > 0 {
 		return errors.New("you can only unassign licenses from either users, serial numbers, or license ids in a single operation")
 	}
@@ -179,11 +381,289 @@ func (op *licenseOperations) UnassignLicenseID(licenseID string) error {
 	return nil
 }
 
+// validate checks operations which were not built with the Assign and Unassign methods, which associate licenses with
+// only one kind of holder, and disassociate licenses from only one kind of holder.
+func (op *LicenseOperations) validate() error {
+	if len(op.AssociateUsers) > 0 && len(op.AssociateSerialNumbers) > 0 {
+		return errors.New("you cannot assign licenses to both users and devices in the same license operation")
+	}
+
+	var kinds int
+	for _, n := range []int{len(op.DisassociateUsers), len(op.DisassociateSerialNumbers), len(op.DisassociateLicenseIDs)} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return errors.New("you can only unassign licenses from either users, serial numbers, or license ids in a single operation")
+	}
+	return nil
+}
+
+// empty reports whether there are no operations to perform.
+func (op *LicenseOperations) empty() bool {
+	return len(op.AssociateUsers) == 0 && len(op.AssociateSerialNumbers) == 0 &&
+		len(op.DisassociateUsers) == 0 && len(op.DisassociateSerialNumbers) == 0 && len(op.DisassociateLicenseIDs) == 0
+}
+
+// request converts the operations into a manageVPPLicensesByAdamIdSrv request.
+func (op *LicenseOperations) request(sToken string, notify bool) *manageVPPLicensesByAdamIdSrvRequest {
+	request := &manageVPPLicensesByAdamIdSrvRequest{
+		AdamIDStr:                 op.Asset.AdamID,
+		PricingParam:              op.Asset.PricingParam,
+		AssociateSerialNumbers:    op.AssociateSerialNumbers,
+		DisassociateLicenseIDStrs: op.DisassociateLicenseIDs,
+		DisassociateSerialNumbers: op.DisassociateSerialNumbers,
+		NotifyDisassociation:      notify,
+		SToken:                    sToken,
+	}
+
+	if request.PricingParam == "" {
+		request.PricingParam = PricingParamStd
+	}
+
+	for _, user := range op.AssociateUsers {
+		request.AssociateClientUserIDStrs = append(request.AssociateClientUserIDStrs, user.ClientUserIdStr)
+	}
+
+	for _, user := range op.DisassociateUsers {
+		request.DisassociateClientUserIDStrs = append(request.DisassociateClientUserIDStrs, user.ClientUserIdStr)
+	}
+
+	return request
+}
+
+// chunk splits the operations into pieces containing at most maxAssociate associations and maxDisassociate
+// disassociations each. A limit of zero or less is treated as unlimited. The operations must have been validated, so
+// that the lists cut at the same bounds hold a single kind of holder.
+func (op *LicenseOperations) chunk(maxAssociate, maxDisassociate int) []*LicenseOperations {
+	associations := len(op.AssociateUsers) + len(op.AssociateSerialNumbers)
+	disassociations := len(op.DisassociateUsers) + len(op.DisassociateSerialNumbers) + len(op.DisassociateLicenseIDs)
+
+	count := chunkCount(associations, maxAssociate)
+	if n := chunkCount(disassociations, maxDisassociate); n > count {
+		count = n
+	}
+
+	if count <= 1 {
+		return []*LicenseOperations{op}
+	}
+
+	chunks := make([]*LicenseOperations, count)
+	for i := range chunks {
+		lo, hi := chunkBounds(associations, maxAssociate, i)
+		dlo, dhi := chunkBounds(disassociations, maxDisassociate, i)
+
+		chunks[i] = &LicenseOperations{
+			Asset:                     op.Asset,
+			AssociateUsers:            chunkUsers(op.AssociateUsers, lo, hi),
+			AssociateSerialNumbers:    chunkStrings(op.AssociateSerialNumbers, lo, hi),
+			DisassociateUsers:         chunkUsers(op.DisassociateUsers, dlo, dhi),
+			DisassociateSerialNumbers: chunkStrings(op.DisassociateSerialNumbers, dlo, dhi),
+			DisassociateLicenseIDs:    chunkStrings(op.DisassociateLicenseIDs, dlo, dhi),
+		}
+	}
+
+	return chunks
+}
+
+func chunkCount(n, size int) int {
+	switch {
+	case n == 0:
+		return 0
+	case size <= 0:
+		return 1
+	default:
+		return (n + size - 1) / size
+	}
+}
+
+func chunkBounds(n, size, i int) (int, int) {
+	if size <= 0 {
+		size = n
+	}
+
+	lo, hi := i*size, (i+1)*size
+	if lo > n {
+		lo = n
+	}
+	if hi > n {
+		hi = n
+	}
+	return lo, hi
+}
+
+func chunkStrings(s []string, lo, hi int) []string {
+	if lo >= len(s) {
+		return nil
+	}
+	if hi > len(s) {
+		hi = len(s)
+	}
+	return s[lo:hi]
+}
+
+func chunkUsers(s []*VPPUser, lo, hi int) []*VPPUser {
+	if lo >= len(s) {
+		return nil
+	}
+	if hi > len(s) {
+		hi = len(s)
+	}
+	return s[lo:hi]
+}
+
+// LicenseChunkError describes a chunk of license operations that could not be submitted to the VPP service.
+type LicenseChunkError struct {
+	Operations *LicenseOperations
+	Err        error
+}
+
+func (e LicenseChunkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e LicenseChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ManageLicensesError is returned by ManageLicenses when an operation was split into chunks and at least one of the
+// chunks failed. The result returned alongside it still contains the outcome of the chunks that succeeded.
+type ManageLicensesError struct {
+	Chunks     []LicenseChunkError
+	ChunkCount int
+}
+
+func (e *ManageLicensesError) Error() string {
+	return fmt.Sprintf("%d of %d license operation chunks failed, first error: %v", len(e.Chunks), e.ChunkCount, e.Chunks[0].Err)
+}
+
 // ManageLicenses is used for the bulk addition/removal of VPP licenses.
 // The notify argument controls whether the user will be notified of the removal.
-//func (s *licensesService) ManageLicenses(operations *licenseOperations, notify bool) ([]LicenseAssociation, error) {
 //
-//}
+// Operations larger than the maxBatchAssociateLicenseCount or maxBatchDisassociateLicenseCount of the service
+// configuration are split into chunks, which are submitted with up to Config.MaxConcurrentLicenseRequests in flight.
+// The per-chunk results are merged in order. If any chunk fails, a *ManageLicensesError is returned alongside the
+// merged result of the chunks that succeeded.
+//
+// Check ManageLicensesResult.Failed() for the individual associations that the VPP service rejected.
+func (s *licensesService) ManageLicenses(operations *LicenseOperations, notify bool) (*ManageLicensesResult, error) {
+	return s.ManageLicensesWithContext(context.Background(), operations, notify)
+}
+
+// ManageLicensesWithContext is like ManageLicenses but carries a context for cancellation and deadlines.
+func (s *licensesService) ManageLicensesWithContext(ctx context.Context, operations *LicenseOperations, notify bool) (*ManageLicensesResult, error) {
+	if operations == nil {
+		return nil, errors.New("no license operations were given")
+	}
+
+	if operations.Asset == nil || operations.Asset.AdamID == "" {
+		return nil, errors.New("license operations must be given an asset with an adam id")
+	}
+
+	if err := operations.validate(); err != nil {
+		return nil, err
+	}
+
+	if operations.empty() {
+		return nil, errors.New("no license operations were given")
+	}
+
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := operations.chunk(serviceConfig.MaxBatchAssociateLicenseCount, serviceConfig.MaxBatchDisassociateLicenseCount)
+	if len(chunks) == 1 {
+		return s.manageLicenses(ctx, chunks[0], notify)
+	}
+
+	results := make([]*ManageLicensesResult, len(chunks))
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, s.client.Config.maxConcurrentLicenseRequests())
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chunk *LicenseOperations) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = s.manageLicenses(ctx, chunk, notify)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	merged := &ManageLicensesResult{}
+	chunkErr := &ManageLicensesError{ChunkCount: len(chunks)}
+	for i, result := range results {
+		if errs[i] != nil {
+			chunkErr.Chunks = append(chunkErr.Chunks, LicenseChunkError{Operations: chunks[i], Err: errs[i]})
+			continue
+		}
+		merged.merge(result)
+	}
+
+	if len(chunkErr.Chunks) > 0 {
+		return merged, chunkErr
+	}
+	return merged, nil
+}
+
+// merge appends the associations of another result, taking the asset details from the first result merged.
+func (r *ManageLicensesResult) merge(other *ManageLicensesResult) {
+	if r.AdamIDStr == "" {
+		r.AdamIDStr = other.AdamIDStr
+		r.ProductTypeID = other.ProductTypeID
+		r.PricingParam = other.PricingParam
+		r.ProductTypeName = other.ProductTypeName
+		r.IsIrrevocable = other.IsIrrevocable
+	}
+
+	r.Associations = append(r.Associations, other.Associations...)
+	r.Disassociations = append(r.Disassociations, other.Disassociations...)
+}
+
+// manageLicenses submits a single manageVPPLicensesByAdamIdSrv request.
+func (s *licensesService) manageLicenses(ctx context.Context, operations *LicenseOperations, notify bool) (*ManageLicensesResult, error) {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response manageVPPLicensesByAdamIdSrvResponse
+	request := operations.request(sToken, notify)
+
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.ManageVPPLicensesByAdamIdSrvURL, request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.client.Do(req, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Status == StatusErr {
+		return nil, s.client.vppError(response.VPPError)
+	}
+
+	for _, association := range response.Associations {
+		s.client.describeError(association.VPPError)
+	}
+	for _, disassociation := range response.Disassociations {
+		s.client.describeError(disassociation.VPPError)
+	}
+
+	return &response.ManageLicensesResult, nil
+}
 
 type associateVPPLicenseWithVPPUserSrvRequest struct {
 	UserID       int          `json:"userId,omitempty"`
@@ -201,11 +681,23 @@ type associateVPPLicenseWithVPPUserSrvResponse struct {
 	*VPPError
 }
 
-// DEPRECATED: Associate an (available) VPP license with a MDM system user.
+// AssociateLicense associates an (available) VPP license with a MDM system user.
+//
+// Deprecated: Apple has deprecated associateVPPLicenseWithVPPUserSrv, use ManageLicenses instead.
 func (s *licensesService) AssociateLicense(user *VPPUser, license *VPPLicense) error {
+	return s.AssociateLicenseWithContext(context.Background(), user, license)
+}
+
+// AssociateLicenseWithContext is like AssociateLicense but carries a context for cancellation and deadlines.
+func (s *licensesService) AssociateLicenseWithContext(ctx context.Context, user *VPPUser, license *VPPLicense) error {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return err
+	}
+
 	var response *associateVPPLicenseWithVPPUserSrvResponse
 	var request *associateVPPLicenseWithVPPUserSrvRequest = &associateVPPLicenseWithVPPUserSrvRequest{
-		SToken: s.sToken,
+		SToken: sToken,
 	}
 
 	// UserID takes precedence over ClientUserID
@@ -222,7 +714,12 @@ func (s *licensesService) AssociateLicense(user *VPPUser, license *VPPLicense) e
 		request.AdamID = license.AdamID
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.AssociateLicenseSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.AssociateLicenseSrvURL, request)
 	if err != nil {
 		return err
 	}
@@ -233,7 +730,7 @@ func (s *licensesService) AssociateLicense(user *VPPUser, license *VPPLicense) e
 	}
 
 	if response.Status == StatusErr {
-		return response.VPPError
+		return s.client.vppError(response.VPPError)
 	}
 
 	user.Status = response.User.Status
@@ -253,16 +750,33 @@ type disassociateVPPLicenseFromVPPUserSrvResponse struct {
 	*VPPError
 }
 
-// DEPRECATED: Disassociate a license from a VPP user.
+// DisassociateLicense disassociates a license from a VPP user.
+//
+// Deprecated: Apple has deprecated disassociateVPPLicenseFromVPPUserSrv, use ManageLicenses instead.
 func (s *licensesService) DisassociateLicense(user *VPPUser, license *VPPLicense) error {
+	return s.DisassociateLicenseWithContext(context.Background(), user, license)
+}
+
+// DisassociateLicenseWithContext is like DisassociateLicense but carries a context for cancellation and deadlines.
+func (s *licensesService) DisassociateLicenseWithContext(ctx context.Context, user *VPPUser, license *VPPLicense) error {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return err
+	}
+
 	var response *disassociateVPPLicenseFromVPPUserSrvResponse
 	var request *disassociateVPPLicenseFromVPPUserSrvRequest = &disassociateVPPLicenseFromVPPUserSrvRequest{
 		UserID:    user.UserID,
 		LicenseID: license.LicenseID,
-		SToken:    s.sToken,
+		SToken:    sToken,
+	}
+
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return err
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.DisassociateLicenseSrvURL, request)
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.DisassociateLicenseSrvURL, request)
 	if err != nil {
 		return err
 	}
@@ -273,7 +787,7 @@ func (s *licensesService) DisassociateLicense(user *VPPUser, license *VPPLicense
 	}
 
 	if response.Status == StatusErr {
-		return response.VPPError
+		return s.client.vppError(response.VPPError)
 	}
 
 	user.Status = response.User.Status
@@ -1,8 +1,15 @@
 package vpp
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/mosen/vpp/vpptest"
 )
 
 func TestLicensesService_GetLicenses(t *testing.T) {
@@ -36,3 +43,285 @@ func TestLicensesService_AssociateLicense(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestLicensesService_GetLicenses_Batches(t *testing.T) {
+	var requests []getVPPLicensesSrvRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request getVPPLicensesSrvRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, request)
+
+		if request.BatchRequest == nil || request.BatchToken == "" {
+			w.Write([]byte(`{"status":0,"totalCount":3,"totalBatchCount":2,"batchToken":"next",
+				"licenses":[{"licenseIdStr":"1"},{"licenseIdStr":"2"}]}`))
+			return
+		}
+		w.Write([]byte(`{"status":0,"sinceModifiedToken":"since","licenses":[{"licenseIdStr":"3"}]}`))
+	}))
+	defer srv.Close()
+
+	it := newTestClient(srv).IterateLicenses(context.Background(), nil)
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.License().LicenseID)
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("expected licenses 1,2,3 but got %v", ids)
+	}
+
+	if len(requests) != 2 || requests[1].BatchToken != "next" {
+		t.Errorf("expected the second request to carry the batch token, got %#v", requests)
+	}
+
+	if it.TotalCount() != 3 || it.TotalBatchCount() != 2 {
+		t.Errorf("expected totals from the first batch, got %d/%d", it.TotalCount(), it.TotalBatchCount())
+	}
+
+	if it.SinceModifiedToken() != "since" {
+		t.Errorf("expected since modified token, got %q", it.SinceModifiedToken())
+	}
+}
+
+func TestLicensesService_ManageLicenses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+
+		if request["adamIdStr"] != "408709785" || request["pricingParam"] != "STDQ" {
+			t.Errorf("unexpected asset in request: %v", request)
+		}
+
+		serials, _ := request["associateSerialNumbers"].([]interface{})
+		if len(serials) != 2 {
+			t.Errorf("expected two serial numbers, got %v", request["associateSerialNumbers"])
+		}
+
+		w.Write([]byte(`{"status":0,"adamIdStr":"408709785","pricingParam":"STDQ","associations":[
+			{"serialNumber":"C02AAAAAAAAA","licenseIdStr":"1"},
+			{"serialNumber":"C02BBBBBBBBB","errorMessage":"License already assigned","errorNumber":9616}
+		]}`))
+	}))
+	defer srv.Close()
+
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	if err := ops.AssignSerialNumber("C02AAAAAAAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ops.AssignSerialNumber("C02BBBBBBBBB"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := newTestClient(srv).ManageLicenses(ops, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Associations) != 2 {
+		t.Fatalf("expected two associations, got %#v", result.Associations)
+	}
+
+	failed := result.Failed()
+	if len(failed) != 1 || failed[0].SerialNumber != "C02BBBBBBBBB" || failed[0].ErrorNumber != 9616 {
+		t.Errorf("expected the second association to fail, got %#v", failed)
+	}
+}
+
+func TestLicenseOperations_MixedAssignments(t *testing.T) {
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	if err := ops.AssignUser(&VPPUser{ClientUserIdStr: clientIdStrFixture}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ops.AssignSerialNumber("C02AAAAAAAAA"); err == nil {
+		t.Error("expected an error assigning to users and devices in the same operation")
+	}
+}
+
+// Operations built without the Assign and Unassign methods are checked before they are chunked.
+func TestLicensesService_ManageLicenses_Invalid(t *testing.T) {
+	client := &licensesService{}
+	asset := &VPPAsset{AdamID: "408709785"}
+	invalid := map[string]*LicenseOperations{
+		"nil": nil,
+		"mixed assignments": {
+			Asset:                  asset,
+			AssociateUsers:         []*VPPUser{{ClientUserIdStr: clientIdStrFixture}},
+			AssociateSerialNumbers: []string{"C02AAAAAAAAA"},
+		},
+		"mixed unassignments": {
+			Asset:                     asset,
+			DisassociateSerialNumbers: []string{"C02AAAAAAAAA"},
+			DisassociateLicenseIDs:    []string{"1"},
+		},
+	}
+
+	for name, ops := range invalid {
+		if _, err := client.ManageLicenses(ops, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLicenseOperations_Chunk(t *testing.T) {
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	for i := 0; i < 5; i++ {
+		ops.AssignSerialNumber(fmt.Sprintf("SERIAL%d", i))
+	}
+	for i := 0; i < 2; i++ {
+		ops.UnassignLicenseID(fmt.Sprintf("%d", i))
+	}
+
+	chunks := ops.chunk(2, 1)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	expected := []struct{ associate, disassociate int }{{2, 1}, {2, 1}, {1, 0}}
+	for i, chunk := range chunks {
+		if len(chunk.AssociateSerialNumbers) != expected[i].associate || len(chunk.DisassociateLicenseIDs) != expected[i].disassociate {
+			t.Errorf("chunk %d: expected %d/%d operations, got %d/%d", i, expected[i].associate, expected[i].disassociate,
+				len(chunk.AssociateSerialNumbers), len(chunk.DisassociateLicenseIDs))
+		}
+	}
+
+	if unlimited := ops.chunk(0, 0); len(unlimited) != 1 || unlimited[0] != ops {
+		t.Errorf("expected no chunking without limits, got %d chunks", len(unlimited))
+	}
+}
+
+func TestLicensesService_ManageLicenses_Chunked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request manageVPPLicensesByAdamIdSrvRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(request.AssociateSerialNumbers) > 2 {
+			t.Errorf("chunk exceeded the maximum batch size: %v", request.AssociateSerialNumbers)
+		}
+
+		if request.AssociateSerialNumbers[0] == "SERIAL2" {
+			w.Write([]byte(`{"status":-1,"errorMessage":"Internal error","errorNumber":9603}`))
+			return
+		}
+
+		response := manageVPPLicensesByAdamIdSrvResponse{}
+		response.AdamIDStr = request.AdamIDStr
+		for _, serial := range request.AssociateSerialNumbers {
+			response.Associations = append(response.Associations, LicenseAssociation{SerialNumber: serial})
+		}
+		json.NewEncoder(w).Encode(&response)
+	}))
+	defer srv.Close()
+
+	client := newTestClient(srv)
+	client.serviceConfig.config.MaxBatchAssociateLicenseCount = 2
+	client.Config.MaxConcurrentLicenseRequests = 2
+
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	for i := 0; i < 5; i++ {
+		ops.AssignSerialNumber(fmt.Sprintf("SERIAL%d", i))
+	}
+
+	result, err := client.ManageLicenses(ops, false)
+	chunkErr, ok := err.(*ManageLicensesError)
+	if !ok {
+		t.Fatalf("expected *ManageLicensesError, got %#v", err)
+	}
+
+	if len(chunkErr.Chunks) != 1 || chunkErr.ChunkCount != 3 {
+		t.Errorf("expected 1 of 3 chunks to fail, got %d of %d", len(chunkErr.Chunks), chunkErr.ChunkCount)
+	}
+
+	var serials []string
+	for _, association := range result.Associations {
+		serials = append(serials, association.SerialNumber)
+	}
+
+	if strings.Join(serials, ",") != "SERIAL0,SERIAL1,SERIAL4" || result.AdamIDStr != "408709785" {
+		t.Errorf("expected the successful chunks to be merged in order, got %v", serials)
+	}
+}
+
+func TestLicensesService_GetLicenses_Filtered(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726", PricingParam: "PLUS"}, 3)
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	devices.AssignSerialNumber("C02AAAAAAAAA")
+	users := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	users.AssignUser(&VPPUser{ClientUserIdStr: clientIdStrFixture})
+	users.AssignUser(&VPPUser{ClientUserIdStr: "user-0"})
+	for _, ops := range []*LicenseOperations{devices, users} {
+		if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		opts  []GetLicensesOption
+		count int
+	}{
+		{"unfiltered", nil, 13},
+		{"assigned only", []GetLicensesOption{AssignedOnly()}, 3},
+		{"adam id", []GetLicensesOption{ForAdamID("361309726")}, 3},
+		{"pricing param", []GetLicensesOption{WithPricingParam(PricingParamStd)}, 10},
+		{"serial number", []GetLicensesOption{ForSerialNumber("C02AAAAAAAAA")}, 1},
+		{"client user id", []GetLicensesOption{ForClientUserID(clientIdStrFixture)}, 1},
+	}
+
+	for _, tt := range tests {
+		licenses, err := vppClient.GetLicenses(&BatchRequest{}, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(licenses) != tt.count {
+			t.Errorf("%s: expected %d licenses, got %d", tt.name, tt.count, len(licenses))
+		}
+	}
+
+	licenses, _ := vppClient.GetLicenses(&BatchRequest{}, ForSerialNumber("C02AAAAAAAAA"))
+	if len(licenses) == 1 && licenses[0].SerialNumber != "C02AAAAAAAAA" {
+		t.Errorf("expected the serial number of the license, got %#v", licenses[0])
+	}
+
+	licenses, _ = vppClient.GetLicenses(&BatchRequest{}, ForClientUserID(clientIdStrFixture))
+	if len(licenses) == 1 && (licenses[0].VPPUser == nil || licenses[0].VPPUser.ClientUserIdStr != clientIdStrFixture) {
+		t.Errorf("expected the license of the user, got %#v", licenses[0])
+	}
+}
+
+func TestGetLicensesOption_Invalid(t *testing.T) {
+	invalid := [][]GetLicensesOption{
+		{ForAdamID("")},
+		{ForAdamID("keynote")},
+		{WithPricingParam("HIGH")},
+		{ForSerialNumber("")},
+		{ForClientUserID("")},
+		{ForSerialNumber("C02AAAAAAAAA"), ForClientUserID(clientIdStrFixture)},
+	}
+
+	client := &licensesService{}
+	for _, opts := range invalid {
+		if _, err := client.GetLicenses(&BatchRequest{}, opts...); err == nil {
+			t.Errorf("expected an error from invalid options")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package vpp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mosen/vpp/internal/redact"
+)
+
+// Logger receives structured log records from the client, as alternating keys and values following the message.
+// Its methods match those of *slog.Logger, which can be given to WithLogger as is.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, args ...interface{}) {}
+func (noopLogger) Info(msg string, args ...interface{})  {}
+func (noopLogger) Warn(msg string, args ...interface{})  {}
+func (noopLogger) Error(msg string, args ...interface{}) {}
+
+func (c *vppClient) log() Logger {
+	if c.logger == nil {
+		return noopLogger{}
+	}
+	return c.logger
+}
+
+// endpointName returns the name of the VPP service endpoint that a URL refers to, eg. "getVPPUsersSrv".
+func endpointName(u *url.URL) string {
+	return path.Base(strings.TrimSuffix(u.Path, "/"))
+}
+
+// logRequestBody logs the body of a request, if body logging is enabled.
+func (c *vppClient) logRequestBody(req *http.Request, endpoint string) {
+	if !c.logBodies || req.GetBody == nil {
+		return
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return
+	}
+	c.log().Debug("vpp request body", "method", req.Method, "endpoint", endpoint, "body", redactBody(data))
+}
+
+// logResponseBody logs the body of a response, if body logging is enabled.
+func (c *vppClient) logResponseBody(req *http.Request, endpoint string, body []byte) {
+	if !c.logBodies {
+		return
+	}
+	c.log().Debug("vpp response body", "method", req.Method, "endpoint", endpoint, "body", redactBody(body))
+}
+
+// redactBody returns a JSON body with the values of sensitive fields replaced, so that it is safe to log.
+// Bodies which cannot be parsed are not logged at all, since they cannot be redacted.
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	redacted, err := redact.JSON(body)
+	if err != nil {
+		return fmt.Sprintf("<%d bytes of unparseable body>", len(body))
+	}
+	return string(redacted)
+}
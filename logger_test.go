@@ -0,0 +1,140 @@
+package vpp
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// *slog.Logger can be used as a Logger as is.
+var _ Logger = slog.Default()
+
+type logRecord struct {
+	level string
+	msg   string
+	args  map[string]interface{}
+}
+
+// recordingLogger keeps every record logged, for inspection by tests.
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := logRecord{level: level, msg: msg, args: make(map[string]interface{})}
+	for i := 0; i+1 < len(args); i += 2 {
+		record.args[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.records = append(l.records, record)
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("info", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("warn", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("error", msg, args) }
+
+func (l *recordingLogger) find(msg string) []logRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found []logRecord
+	for _, record := range l.records {
+		if record.msg == msg {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+func TestVppClient_Do_Logs(t *testing.T) {
+	srv, _ := retryAfterServer(t, 1, "0")
+	defer srv.Close()
+
+	logger := &recordingLogger{}
+	c := &vppClient{client: srv.Client(), Config: &Config{}, logger: logger}
+	req, err := c.NewRequest("POST", srv.URL+"/getVPPUsersSrv", &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Do(req, &getVPPUsersSrvResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	retries := logger.find("vpp service busy, retrying")
+	if len(retries) != 1 || retries[0].level != "warn" || retries[0].args["status"] != 503 {
+		t.Errorf("expected a warning about the retry, got %#v", retries)
+	}
+
+	requests := logger.find("vpp request")
+	if len(requests) != 1 {
+		t.Fatalf("expected the request to be logged, got %#v", logger.records)
+	}
+	args := requests[0].args
+	if args["method"] != "POST" || args["endpoint"] != "getVPPUsersSrv" || args["status"] != 200 || args["retries"] != 1 {
+		t.Errorf("unexpected request log %#v", args)
+	}
+	if _, ok := args["latency"]; !ok {
+		t.Error("expected the latency to be logged")
+	}
+
+	if bodies := logger.find("vpp request body"); len(bodies) != 0 {
+		t.Errorf("expected bodies not to be logged by default, got %#v", bodies)
+	}
+}
+
+func TestVppClient_Do_LogsRedactedBodies(t *testing.T) {
+	setup()
+	defer teardown()
+
+	logger := &recordingLogger{}
+	vppClient, err := NewVPPClient(config, WithLogger(logger), WithBodyLogging())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := vppClient.RegisterUser(&VPPUser{ClientUserIdStr: "logged-user", Email: "secret@localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.InviteCode == "" || user.InviteURL == "" {
+		t.Fatalf("expected an invitation, got %#v", user)
+	}
+
+	var bodies []string
+	for _, record := range append(logger.find("vpp request body"), logger.find("vpp response body")...) {
+		bodies = append(bodies, record.args["body"].(string))
+	}
+	if len(bodies) == 0 {
+		t.Fatal("expected bodies to be logged")
+	}
+
+	for _, body := range bodies {
+		for _, secret := range []string{config.SToken, "secret@localhost", user.InviteCode, user.InviteURL} {
+			if strings.Contains(body, secret) {
+				t.Errorf("expected %q to be redacted from %s", secret, body)
+			}
+		}
+	}
+
+	if !strings.Contains(strings.Join(bodies, ""), "logged-user") {
+		t.Errorf("expected the rest of the bodies to be logged, got %v", bodies)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body := redactBody([]byte(`{"sToken":"abc","users":[{"email":"a@b","InviteCode":"x","clientUserIdStr":"1"}]}`))
+	if body != `{"sToken":"REDACTED","users":[{"InviteCode":"REDACTED","clientUserIdStr":"1","email":"REDACTED"}]}` {
+		t.Errorf("unexpected redacted body %s", body)
+	}
+
+	if body := redactBody([]byte(`{"sToken":"abc"`)); strings.Contains(body, "abc") {
+		t.Errorf("expected an unparseable body not to be logged, got %s", body)
+	}
+}
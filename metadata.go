@@ -1,13 +1,247 @@
 package vpp
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLookupURL = "https://itunes.apple.com/lookup"
+
+	// maxLookupIDs is the number of adam ids looked up in each request to the lookup endpoint.
+	maxLookupIDs = 100
+)
+
+// AssetMetadata describes an app or book as it is listed in the store.
+type AssetMetadata struct {
+	AdamID   string
+	Name     string
+	Kind     string // eg. software, mac-software or ebook
+	BundleID string
+	Version  string
+
+	// Platforms lists the platforms that an app supports, eg. iOS, iPadOS or macOS.
+	Platforms []string
+	IconURL   string
+
+	Price          float64
+	FormattedPrice string
+	Currency       string
+}
+
+// MetadataService describes an interface that is capable of looking up store listings for VPP assets, so that they
+// can be presented by name rather than by adam id.
 type MetadataService interface {
+	LookupMetadata(adamIDs ...string) (map[string]*AssetMetadata, error)
+	LookupMetadataWithContext(ctx context.Context, adamIDs ...string) (map[string]*AssetMetadata, error)
+	AddMetadata(assets Assets) error
+	AddMetadataWithContext(ctx context.Context, assets Assets) error
 }
 
 type metadataService struct {
 	client *vppClient
-	sToken string
+
+	lookupURL *url.URL
+	country   string
+}
+
+type lookupResponse struct {
+	ResultCount int            `json:"resultCount"`
+	Results     []lookupResult `json:"results"`
+}
+
+type lookupResult struct {
+	TrackID          int      `json:"trackId"`
+	TrackName        string   `json:"trackName"`
+	Kind             string   `json:"kind"`
+	BundleID         string   `json:"bundleId"`
+	Version          string   `json:"version"`
+	SupportedDevices []string `json:"supportedDevices"`
+	ArtworkURL100    string   `json:"artworkUrl100"`
+	ArtworkURL512    string   `json:"artworkUrl512"`
+	Price            float64  `json:"price"`
+	FormattedPrice   string   `json:"formattedPrice"`
+	Currency         string   `json:"currency"`
+}
+
+func (r *lookupResult) metadata() *AssetMetadata {
+	iconURL := r.ArtworkURL512
+	if iconURL == "" {
+		iconURL = r.ArtworkURL100
+	}
+
+	return &AssetMetadata{
+		AdamID:         strconv.Itoa(r.TrackID),
+		Name:           r.TrackName,
+		Kind:           r.Kind,
+		BundleID:       r.BundleID,
+		Version:        r.Version,
+		Platforms:      r.platforms(),
+		IconURL:        iconURL,
+		Price:          r.Price,
+		FormattedPrice: r.FormattedPrice,
+		Currency:       r.Currency,
+	}
+}
+
+// platformPrefixes maps the prefixes of supported device names to the platform of the device.
+var platformPrefixes = []struct{ prefix, platform string }{
+	{"iPhone", "iOS"},
+	{"iPod", "iOS"},
+	{"iPad", "iPadOS"},
+	{"AppleTV", "tvOS"},
+	{"Watch", "watchOS"},
+}
+
+// platforms derives the supported platforms of an app from its kind and supported devices.
+func (r *lookupResult) platforms() []string {
+	if r.Kind == "mac-software" {
+		return []string{"macOS"}
+	}
+
+	var platforms []string
+	seen := make(map[string]bool)
+	for _, device := range r.SupportedDevices {
+		for _, p := range platformPrefixes {
+			if strings.HasPrefix(device, p.prefix) && !seen[p.platform] {
+				seen[p.platform] = true
+				platforms = append(platforms, p.platform)
+			}
+		}
+	}
+	return platforms
 }
 
-func (ms *metadataService) LookupURL() {
+// LookupMetadata finds the store listings of the given apps and books, keyed by adam id.
+// Adam ids which are not listed in the store, eg. custom apps, are missing from the result.
+func (s *metadataService) LookupMetadata(adamIDs ...string) (map[string]*AssetMetadata, error) {
+	return s.LookupMetadataWithContext(context.Background(), adamIDs...)
+}
+
+// LookupMetadataWithContext is like LookupMetadata but carries a context for cancellation and deadlines.
+func (s *metadataService) LookupMetadataWithContext(ctx context.Context, adamIDs ...string) (map[string]*AssetMetadata, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, adamID := range adamIDs {
+		if _, err := strconv.Atoi(adamID); err != nil {
+			return nil, fmt.Errorf("adam id %q is not a number", adamID)
+		}
+		if !seen[adamID] {
+			seen[adamID] = true
+			ids = append(ids, adamID)
+		}
+	}
+
+	metadata := make(map[string]*AssetMetadata, len(ids))
+	for start := 0; start < len(ids); start += maxLookupIDs {
+		end := start + maxLookupIDs
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		results, err := s.lookup(ctx, ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			if seen[strconv.Itoa(result.TrackID)] {
+				metadata[strconv.Itoa(result.TrackID)] = result.metadata()
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+// lookup sends a single request to the lookup endpoint.
+// The request is sent with the HTTP client of the VPP client but not through Do, so that lookups are not held up by
+// the rate limits of the VPP service, cannot pause VPP requests with a Retry-After, and are not counted in its
+// metrics.
+func (s *metadataService) lookup(ctx context.Context, adamIDs []string) ([]lookupResult, error) {
+	u := *s.endpoint()
+	query := u.Query()
+	query.Set("id", strings.Join(adamIDs, ","))
+	if s.country != "" {
+		query.Set("country", s.country)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.client.UserAgent != "" {
+		req.Header.Set("User-Agent", s.client.UserAgent)
+	} else {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	start := time.Now()
+	resp, err := s.client.client.Do(req)
+	if err != nil {
+		s.client.log().Error("metadata lookup failed", "count", len(adamIDs), "latency", time.Since(start), "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		s.client.log().Error("metadata lookup failed", "count", len(adamIDs), "status", resp.StatusCode,
+			"latency", time.Since(start))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+	}
+	s.client.log().Debug("metadata lookup", "count", len(adamIDs), "status", resp.StatusCode, "latency", time.Since(start))
+
+	var response lookupResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+func (s *metadataService) endpoint() *url.URL {
+	if s.lookupURL != nil {
+		return s.lookupURL
+	}
+	u, _ := url.Parse(defaultLookupURL)
+	return u
+}
+
+// AddMetadata looks up the store listings of the given assets, setting their Metadata.
+// Assets which are not listed in the store are left without metadata.
+func (s *metadataService) AddMetadata(assets Assets) error {
+	return s.AddMetadataWithContext(context.Background(), assets)
+}
+
+// AddMetadataWithContext is like AddMetadata but carries a context for cancellation and deadlines.
+func (s *metadataService) AddMetadataWithContext(ctx context.Context, assets Assets) error {
+	return addMetadata(ctx, s.LookupMetadataWithContext, assets)
+}
+
+// addMetadata sets the Metadata of the assets using the given lookup function.
+func addMetadata(ctx context.Context, lookup func(context.Context, ...string) (map[string]*AssetMetadata, error), assets Assets) error {
+	adamIDs := make([]string, len(assets))
+	for i, asset := range assets {
+		adamIDs[i] = asset.AdamIdStr
+	}
+
+	metadata, err := lookup(ctx, adamIDs...)
+	if err != nil {
+		return err
+	}
 
+	for i := range assets {
+		assets[i].Metadata = metadata[assets[i].AdamIdStr]
+	}
+	return nil
 }
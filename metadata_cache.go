@@ -0,0 +1,425 @@
+package vpp
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMetadataCacheSize is the number of adam ids kept in memory by a MetadataCache.
+	DefaultMetadataCacheSize = 10000
+
+	// DefaultMetadataCacheTTL is how long the store listing of an asset is cached.
+	DefaultMetadataCacheTTL = 24 * time.Hour
+
+	// DefaultMetadataNegativeTTL is how long an adam id which is not listed in the store is remembered as such.
+	DefaultMetadataNegativeTTL = time.Hour
+)
+
+// MetadataCacheEntry is the result of looking up a single adam id.
+type MetadataCacheEntry struct {
+	AdamID string `json:"adamId"`
+
+	// Metadata is nil if the adam id is not listed in the store.
+	Metadata  *AssetMetadata `json:"metadata,omitempty"`
+	FetchedAt time.Time      `json:"fetchedAt"`
+}
+
+// MetadataStore persists the entries of a MetadataCache, so that they survive a restart.
+type MetadataStore interface {
+	Get(adamID string) (*MetadataCacheEntry, error)
+	Put(entry *MetadataCacheEntry) error
+}
+
+// MetadataCache is a MetadataService which caches the store listings found by another MetadataService.
+// Listings are kept in memory, up to a number of adam ids, and optionally in a MetadataStore. Adam ids which are not
+// listed in the store are cached too. Concurrent lookups of the same adam id share a single request.
+type MetadataCache struct {
+	service     MetadataService
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	store       MetadataStore
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inflight map[string]*metadataCall
+}
+
+// metadataCall is a lookup of a single adam id which other goroutines may wait for.
+type metadataCall struct {
+	done     chan struct{}
+	metadata *AssetMetadata
+	err      error
+
+	// abandoned is set if the lookup failed because the context of the goroutine leading it was done, rather than
+	// because the service failed, in which case the goroutines waiting for it look the adam id up again.
+	abandoned bool
+}
+
+// MetadataCacheOption describes the signature of the closure returned by a function adding an option to
+// NewMetadataCache
+type MetadataCacheOption func(*MetadataCache) error
+
+// MetadataCacheSize sets the number of adam ids kept in memory, after which the least recently used are evicted.
+func MetadataCacheSize(size int) MetadataCacheOption {
+	return func(c *MetadataCache) error {
+		if size < 1 {
+			return errors.New("cache size must be at least one")
+		}
+		c.size = size
+		return nil
+	}
+}
+
+// MetadataCacheTTL sets how long the store listing of an asset is cached.
+func MetadataCacheTTL(ttl time.Duration) MetadataCacheOption {
+	return func(c *MetadataCache) error {
+		if ttl <= 0 {
+			return errors.New("cache ttl must be greater than zero")
+		}
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// MetadataNegativeTTL sets how long an adam id which is not listed in the store is remembered as such.
+// Zero disables negative caching.
+func MetadataNegativeTTL(ttl time.Duration) MetadataCacheOption {
+	return func(c *MetadataCache) error {
+		if ttl < 0 {
+			return errors.New("negative cache ttl must not be less than zero")
+		}
+		c.negativeTTL = ttl
+		return nil
+	}
+}
+
+// MetadataCacheStore persists the entries of the cache in the given store, such as a FileMetadataStore.
+// Errors of the store are treated as cache misses and do not fail lookups.
+func MetadataCacheStore(store MetadataStore) MetadataCacheOption {
+	return func(c *MetadataCache) error {
+		if store == nil {
+			return errors.New("no metadata store given")
+		}
+		c.store = store
+		return nil
+	}
+}
+
+// NewMetadataCache creates a cache in front of the given MetadataService, usually a VPPClient.
+func NewMetadataCache(service MetadataService, opts ...MetadataCacheOption) (*MetadataCache, error) {
+	if service == nil {
+		return nil, errors.New("no metadata service given")
+	}
+
+	c := &MetadataCache{
+		service:     service,
+		size:        DefaultMetadataCacheSize,
+		ttl:         DefaultMetadataCacheTTL,
+		negativeTTL: DefaultMetadataNegativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]*metadataCall),
+	}
+	for _, option := range opts {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LookupMetadata finds the store listings of the given apps and books, keyed by adam id, from the cache where
+// possible.
+func (c *MetadataCache) LookupMetadata(adamIDs ...string) (map[string]*AssetMetadata, error) {
+	return c.LookupMetadataWithContext(context.Background(), adamIDs...)
+}
+
+// LookupMetadataWithContext is like LookupMetadata but carries a context for cancellation and deadlines.
+func (c *MetadataCache) LookupMetadataWithContext(ctx context.Context, adamIDs ...string) (map[string]*AssetMetadata, error) {
+	for _, adamID := range adamIDs {
+		if _, err := strconv.Atoi(adamID); err != nil {
+			return nil, fmt.Errorf("adam id %q is not a number", adamID)
+		}
+	}
+
+	metadata := make(map[string]*AssetMetadata, len(adamIDs))
+	var misses []string
+	seen := make(map[string]bool)
+
+	c.mu.Lock()
+	for _, adamID := range adamIDs {
+		if seen[adamID] {
+			continue
+		}
+		seen[adamID] = true
+		if entry, ok := c.cached(adamID); ok {
+			if entry.Metadata != nil {
+				metadata[adamID] = entry.Metadata
+			}
+			continue
+		}
+		misses = append(misses, adamID)
+	}
+	c.mu.Unlock()
+
+	if len(misses) == 0 {
+		return metadata, nil
+	}
+
+	// The store is read without holding the lock, so that memory hits are not held up by it.
+	stored := c.load(misses)
+
+	waiting := make(map[string]*metadataCall)
+	leading := make(map[string]*metadataCall)
+	c.mu.Lock()
+	for _, adamID := range misses {
+		if entry, ok := stored[adamID]; ok {
+			c.remember(entry)
+		}
+		if entry, ok := c.cached(adamID); ok {
+			if entry.Metadata != nil {
+				metadata[adamID] = entry.Metadata
+			}
+			continue
+		}
+		if call := c.inflight[adamID]; call != nil {
+			waiting[adamID] = call
+			continue
+		}
+		call := &metadataCall{done: make(chan struct{})}
+		c.inflight[adamID] = call
+		leading[adamID] = call
+	}
+	c.mu.Unlock()
+
+	if len(leading) > 0 {
+		if err := c.lookup(ctx, leading); err != nil {
+			return nil, err
+		}
+		for adamID, call := range leading {
+			if call.metadata != nil {
+				metadata[adamID] = call.metadata
+			}
+		}
+	}
+
+	var retry []string
+	for adamID, call := range waiting {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-call.done:
+		}
+		if call.abandoned {
+			retry = append(retry, adamID)
+			continue
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		if call.metadata != nil {
+			metadata[adamID] = call.metadata
+		}
+	}
+
+	if len(retry) > 0 {
+		retried, err := c.LookupMetadataWithContext(ctx, retry...)
+		if err != nil {
+			return nil, err
+		}
+		for adamID, m := range retried {
+			metadata[adamID] = m
+		}
+	}
+
+	return metadata, nil
+}
+
+// lookup asks the underlying service for the adam ids that this goroutine is leading the lookup of, caching the
+// results and handing them to any goroutines waiting for them.
+func (c *MetadataCache) lookup(ctx context.Context, calls map[string]*metadataCall) error {
+	adamIDs := make([]string, 0, len(calls))
+	for adamID := range calls {
+		adamIDs = append(adamIDs, adamID)
+	}
+
+	found, err := c.service.LookupMetadataWithContext(ctx, adamIDs...)
+	now := c.now()
+
+	var entries []*MetadataCacheEntry
+	c.mu.Lock()
+	for adamID, call := range calls {
+		delete(c.inflight, adamID)
+		if err != nil {
+			call.err = err
+			call.abandoned = ctx.Err() != nil
+		} else {
+			call.metadata = found[adamID]
+			entry := &MetadataCacheEntry{AdamID: adamID, Metadata: call.metadata, FetchedAt: now}
+			if c.cacheable(entry) {
+				c.remember(entry)
+				entries = append(entries, entry)
+			}
+		}
+		close(call.done)
+	}
+	c.mu.Unlock()
+
+	if c.store != nil {
+		for _, entry := range entries {
+			c.store.Put(entry)
+		}
+	}
+	return err
+}
+
+// cached returns the entry for an adam id from memory if it has not expired.
+// The cache must be locked by the caller.
+func (c *MetadataCache) cached(adamID string) (*MetadataCacheEntry, bool) {
+	element, ok := c.entries[adamID]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*MetadataCacheEntry)
+	if c.fresh(entry) {
+		c.lru.MoveToFront(element)
+		return entry, true
+	}
+	c.lru.Remove(element)
+	delete(c.entries, adamID)
+	return nil, false
+}
+
+// load reads the entries of the given adam ids which have not expired from the store, if there is one.
+// The cache must not be locked by the caller.
+func (c *MetadataCache) load(adamIDs []string) map[string]*MetadataCacheEntry {
+	entries := make(map[string]*MetadataCacheEntry)
+	if c.store == nil {
+		return entries
+	}
+
+	for _, adamID := range adamIDs {
+		entry, err := c.store.Get(adamID)
+		if err == nil && entry != nil && c.fresh(entry) {
+			entries[adamID] = entry
+		}
+	}
+	return entries
+}
+
+// cacheable reports whether an entry is cached. Entries for unknown adam ids are not cached if negative caching is
+// disabled.
+func (c *MetadataCache) cacheable(entry *MetadataCacheEntry) bool {
+	return entry.Metadata != nil || c.negativeTTL > 0
+}
+
+// remember adds an entry to memory, evicting the least recently used entries beyond the size of the cache.
+// The cache must be locked by the caller.
+func (c *MetadataCache) remember(entry *MetadataCacheEntry) {
+	if element, ok := c.entries[entry.AdamID]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[entry.AdamID] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*MetadataCacheEntry).AdamID)
+	}
+}
+
+func (c *MetadataCache) fresh(entry *MetadataCacheEntry) bool {
+	ttl := c.ttl
+	if entry.Metadata == nil {
+		ttl = c.negativeTTL
+	}
+	return c.now().Sub(entry.FetchedAt) < ttl
+}
+
+// AddMetadata looks up the store listings of the given assets, setting their Metadata.
+func (c *MetadataCache) AddMetadata(assets Assets) error {
+	return c.AddMetadataWithContext(context.Background(), assets)
+}
+
+// AddMetadataWithContext is like AddMetadata but carries a context for cancellation and deadlines.
+func (c *MetadataCache) AddMetadataWithContext(ctx context.Context, assets Assets) error {
+	return addMetadata(ctx, c.LookupMetadataWithContext, assets)
+}
+
+// FileMetadataStore is a MetadataStore keeping each entry in a JSON file in a directory.
+type FileMetadataStore struct {
+	dir string
+}
+
+// NewFileMetadataStore creates a store in the given directory, creating the directory if necessary.
+func NewFileMetadataStore(dir string) (*FileMetadataStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileMetadataStore{dir: dir}, nil
+}
+
+func (s *FileMetadataStore) path(adamID string) string {
+	return filepath.Join(s.dir, adamID+".json")
+}
+
+// Get reads the entry for an adam id, returning nil if there is none.
+func (s *FileMetadataStore) Get(adamID string) (*MetadataCacheEntry, error) {
+	data, err := ioutil.ReadFile(s.path(adamID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &MetadataCacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Put writes the entry for an adam id, replacing any previous entry.
+func (s *FileMetadataStore) Put(entry *MetadataCacheEntry) error {
+	if _, err := strconv.Atoi(entry.AdamID); err != nil {
+		return fmt.Errorf("adam id %q is not a number", entry.AdamID)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, entry.AdamID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(entry.AdamID))
+}
@@ -0,0 +1,272 @@
+package vpp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingMetadataService knows every adam id below 1000, and records the lookups made.
+type countingMetadataService struct {
+	mu      sync.Mutex
+	lookups [][]string
+	release chan struct{}
+	err     error
+}
+
+func (s *countingMetadataService) LookupMetadata(adamIDs ...string) (map[string]*AssetMetadata, error) {
+	return s.LookupMetadataWithContext(context.Background(), adamIDs...)
+}
+
+func (s *countingMetadataService) LookupMetadataWithContext(ctx context.Context, adamIDs ...string) (map[string]*AssetMetadata, error) {
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	sorted := append([]string(nil), adamIDs...)
+	sort.Strings(sorted)
+	s.mu.Lock()
+	s.lookups = append(s.lookups, sorted)
+	s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	metadata := make(map[string]*AssetMetadata)
+	for _, adamID := range adamIDs {
+		if len(adamID) < 4 {
+			metadata[adamID] = &AssetMetadata{AdamID: adamID, Name: "App " + adamID}
+		}
+	}
+	return metadata, nil
+}
+
+func (s *countingMetadataService) AddMetadata(assets Assets) error { return nil }
+
+func (s *countingMetadataService) AddMetadataWithContext(ctx context.Context, assets Assets) error {
+	return nil
+}
+
+func (s *countingMetadataService) lookupCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.lookups)
+}
+
+func TestMetadataCache_TTL(t *testing.T) {
+	service := &countingMetadataService{}
+	cache, err := NewMetadataCache(service, MetadataCacheTTL(time.Hour), MetadataNegativeTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	metadata, err := cache.LookupMetadata("1", "2", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata) != 2 || metadata["1"].Name != "App 1" {
+		t.Fatalf("unexpected metadata %#v", metadata)
+	}
+
+	// Known and unknown adam ids are both cached.
+	cache.LookupMetadata("1", "2", "1000")
+	if count := service.lookupCount(); count != 1 {
+		t.Errorf("expected a single lookup, got %d", count)
+	}
+
+	// The unknown adam id expires first.
+	now = now.Add(2 * time.Minute)
+	cache.LookupMetadata("1", "2", "1000", "3")
+	if lookups := service.lookups; len(lookups) != 2 || strings.Join(lookups[1], ",") != "1000,3" {
+		t.Errorf("expected the unknown and new adam ids to be looked up, got %v", lookups)
+	}
+
+	now = now.Add(time.Hour)
+	cache.LookupMetadata("1")
+	if lookups := service.lookups; len(lookups) != 3 || strings.Join(lookups[2], ",") != "1" {
+		t.Errorf("expected the expired adam id to be looked up again, got %v", lookups)
+	}
+}
+
+func TestMetadataCache_LRU(t *testing.T) {
+	service := &countingMetadataService{}
+	cache, err := NewMetadataCache(service, MetadataCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.LookupMetadata("1")
+	cache.LookupMetadata("2")
+	cache.LookupMetadata("1")
+	cache.LookupMetadata("3") // evicts 2, the least recently used
+	cache.LookupMetadata("1")
+	if count := service.lookupCount(); count != 3 {
+		t.Errorf("expected 3 lookups, got %d", count)
+	}
+
+	cache.LookupMetadata("2")
+	if count := service.lookupCount(); count != 4 {
+		t.Errorf("expected the evicted adam id to be looked up again, got %d lookups", count)
+	}
+}
+
+func TestMetadataCache_SingleFlight(t *testing.T) {
+	service := &countingMetadataService{release: make(chan struct{})}
+	cache, err := NewMetadataCache(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]*AssetMetadata, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.LookupMetadata("42")
+		}(i)
+	}
+
+	// Give the lookups time to start before the first of them completes.
+	time.Sleep(50 * time.Millisecond)
+	close(service.release)
+	wg.Wait()
+
+	if count := service.lookupCount(); count != 1 {
+		t.Errorf("expected concurrent lookups to share a request, got %d", count)
+	}
+	for _, result := range results {
+		if result["42"] == nil {
+			t.Errorf("expected every caller to receive the metadata, got %#v", result)
+		}
+	}
+}
+
+func TestMetadataCache_LeaderCancelled(t *testing.T) {
+	service := &countingMetadataService{release: make(chan struct{})}
+	cache, err := NewMetadataCache(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := cache.LookupMetadataWithContext(ctx, "42")
+		leaderErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	waiter := make(chan map[string]*AssetMetadata)
+	go func() {
+		metadata, err := cache.LookupMetadata("42")
+		if err != nil {
+			t.Errorf("expected the waiting lookup not to fail with the error of another caller, got %v", err)
+		}
+		waiter <- metadata
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("expected the cancelled lookup to fail, got %v", err)
+	}
+	close(service.release)
+
+	if metadata := <-waiter; metadata["42"] == nil {
+		t.Errorf("expected the waiting lookup to be made again, got %#v", metadata)
+	}
+}
+
+// A lookup which times out, eg. because of the timeout of the HTTP client, is not repeated by every waiting caller.
+func TestMetadataCache_LeaderTimedOut(t *testing.T) {
+	timeout := fmt.Errorf("lookup: %w", context.DeadlineExceeded)
+	service := &countingMetadataService{release: make(chan struct{}), err: timeout}
+	cache, err := NewMetadataCache(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := cache.LookupMetadata("42")
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(service.release)
+
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != timeout {
+			t.Errorf("expected every caller to receive the timeout, got %v", err)
+		}
+	}
+	if count := service.lookupCount(); count != 1 {
+		t.Errorf("expected a single lookup, got %d", count)
+	}
+}
+
+func TestMetadataCache_FileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileMetadataStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &countingMetadataService{}
+	cache, err := NewMetadataCache(service, MetadataCacheStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.LookupMetadata("1", "1000")
+
+	// A new cache, as after a restart, reads the entries of the store.
+	restarted, err := NewMetadataCache(service, MetadataCacheStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := restarted.LookupMetadata("1", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata) != 1 || metadata["1"].Name != "App 1" {
+		t.Errorf("unexpected metadata %#v", metadata)
+	}
+	if count := service.lookupCount(); count != 1 {
+		t.Errorf("expected the store to be used after a restart, got %d lookups", count)
+	}
+}
+
+func TestMetadataCache_AddMetadata(t *testing.T) {
+	cache, err := NewMetadataCache(&countingMetadataService{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assets := Assets{{AdamIdStr: "1"}, {AdamIdStr: "1000"}}
+	if err := cache.AddMetadata(assets); err != nil {
+		t.Fatal(err)
+	}
+	if assets[0].Metadata == nil || assets[1].Metadata != nil {
+		t.Errorf("unexpected metadata %#v", assets)
+	}
+}
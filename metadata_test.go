@@ -0,0 +1,133 @@
+package vpp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/mosen/vpp/vpptest"
+)
+
+func TestMetadataService_AddMetadata(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726", PricingParam: "PLUS", ProductTypeID: 10}, 1)
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "409183694"}, 1)
+	vppSim.AddStoreItem(vpptest.StoreItem{
+		TrackID:          408709785,
+		TrackName:        "Keynote",
+		Kind:             "software",
+		BundleID:         "com.apple.Keynote",
+		Version:          "13.1",
+		SupportedDevices: []string{"iPhone5s-iPhone5s", "iPadAir-iPadAir", "iPodTouchSeventhGen-iPodTouchSeventhGen"},
+		ArtworkURL100:    "https://example.com/100.png",
+		ArtworkURL512:    "https://example.com/512.png",
+		FormattedPrice:   "Free",
+		Currency:         "USD",
+	})
+	vppSim.AddStoreItem(vpptest.StoreItem{TrackID: 409183694, TrackName: "Keynote", Kind: "mac-software", BundleID: "com.apple.iWork.Keynote"})
+
+	vppClient, err := NewVPPClient(config, WithLookupURL(vppSim.LookupURL()), WithLookupCountry("AU"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assets, err := vppClient.GetAssets(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vppClient.AddMetadata(assets); err != nil {
+		t.Fatal(err)
+	}
+
+	app, _ := assets.ByAdamID("408709785")
+	expected := &AssetMetadata{
+		AdamID:         "408709785",
+		Name:           "Keynote",
+		Kind:           "software",
+		BundleID:       "com.apple.Keynote",
+		Version:        "13.1",
+		Platforms:      []string{"iOS", "iPadOS"},
+		IconURL:        "https://example.com/512.png",
+		FormattedPrice: "Free",
+		Currency:       "USD",
+	}
+	if !reflect.DeepEqual(app.Metadata, expected) {
+		t.Errorf("expected %#v, got %#v", expected, app.Metadata)
+	}
+
+	if mac, _ := assets.ByAdamID("409183694"); mac.Metadata == nil || !reflect.DeepEqual(mac.Metadata.Platforms, []string{"macOS"}) {
+		t.Errorf("expected a Mac app, got %#v", mac.Metadata)
+	}
+
+	if book, _ := assets.ByAdamID("361309726"); book.Metadata != nil {
+		t.Errorf("expected no metadata for an item missing from the store, got %#v", book.Metadata)
+	}
+}
+
+func TestMetadataService_LookupMetadata_Bulk(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var adamIDs []string
+	for i := 1; i <= 250; i++ {
+		vppSim.AddStoreItem(vpptest.StoreItem{TrackID: i, TrackName: "App " + strconv.Itoa(i), Kind: "software"})
+		adamIDs = append(adamIDs, strconv.Itoa(i))
+	}
+
+	vppClient, err := NewVPPClient(config, WithLookupURL(vppSim.LookupURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, err := vppClient.LookupMetadata(append(adamIDs, "1", "999")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata) != 250 || metadata["250"].Name != "App 250" {
+		t.Errorf("expected 250 apps, got %d", len(metadata))
+	}
+	if requests := vppSim.Requests("lookup"); requests != 3 {
+		t.Errorf("expected the lookup to be split into 3 requests, got %d", requests)
+	}
+
+	if _, err := vppClient.LookupMetadata("keynote"); err == nil {
+		t.Error("expected an error for an invalid adam id")
+	}
+}
+
+// Lookups are sent to Apple's store rather than the VPP service, so they bypass its rate limits and metrics.
+func TestMetadataService_LookupMetadata_BypassesVPP(t *testing.T) {
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	instrumentation := &recordingInstrumentation{}
+	client, err := NewVPPClient(&Config{}, WithLookupURL(srv.URL), WithInstrumentation(instrumentation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.LookupMetadata("408709785")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the lookup to fail without retrying, got %v", err)
+	}
+	if contentType != "" {
+		t.Errorf("expected no Content-Type on a lookup, got %q", contentType)
+	}
+	if len(instrumentation.requests) != 0 {
+		t.Errorf("expected the lookup not to be counted as a VPP request, got %#v", instrumentation.requests)
+	}
+	if until := client.(*vppClient).limiter.pausedUntil; !until.IsZero() {
+		t.Errorf("expected the Retry-After of the lookup not to pause VPP requests, got %s", until)
+	}
+}
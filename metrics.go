@@ -0,0 +1,229 @@
+package vpp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestMetrics describes a single call to Do, as reported to an Instrumentation hook.
+type RequestMetrics struct {
+	// Endpoint is the name of the VPP service endpoint, eg. "getVPPUsersSrv".
+	Endpoint string
+	Method   string
+
+	// StatusCode is the HTTP status of the last response, or zero if no response was received.
+	StatusCode int
+
+	// Duration is the time taken by the call, including retries and waiting for rate limits.
+	Duration time.Duration
+	Retries  int
+
+	// ErrorNumber is the VPP error number given in the response body, or zero if the request succeeded.
+	ErrorNumber int
+	Err         error
+}
+
+// Instrumentation is called by the client after every request it sends.
+// It is called from every goroutine using the client, so implementations must be safe for concurrent use.
+type Instrumentation interface {
+	ObserveRequest(metrics RequestMetrics)
+}
+
+func (c *vppClient) observe(metrics RequestMetrics) {
+	if metrics.Method == "" {
+		// The request could not be built, so it was never sent.
+		return
+	}
+	for _, instrumentation := range c.instrumentation {
+		instrumentation.ObserveRequest(metrics)
+	}
+}
+
+// peekErrorNumber returns the VPP error number of a response body which reports an error, or zero.
+// It decodes the body a second time, so it is only used when Instrumentation is configured.
+func peekErrorNumber(body []byte) int {
+	var response struct {
+		Status      Status `json:"status"`
+		ErrorNumber int    `json:"errorNumber"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Status != StatusErr {
+		return 0
+	}
+	return response.ErrorNumber
+}
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the request duration histogram of PrometheusMetrics.
+var DefaultDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// PrometheusMetrics is an Instrumentation hook counting requests, retries and VPP errors, and recording request
+// durations, per endpoint. It serves the metrics in the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	requests  map[[2]string]uint64
+	vppErrors map[[2]string]uint64
+	retries   map[string]uint64
+	durations map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusMetrics creates an empty set of metrics. The duration histogram uses DefaultDurationBuckets unless
+// buckets are given.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &PrometheusMetrics{
+		buckets:   buckets,
+		requests:  make(map[[2]string]uint64),
+		vppErrors: make(map[[2]string]uint64),
+		retries:   make(map[string]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// ObserveRequest records a request.
+func (m *PrometheusMetrics) ObserveRequest(metrics RequestMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code := "none"
+	if metrics.StatusCode != 0 {
+		code = strconv.Itoa(metrics.StatusCode)
+	}
+	m.requests[[2]string{metrics.Endpoint, code}]++
+
+	if metrics.ErrorNumber != 0 {
+		m.vppErrors[[2]string{metrics.Endpoint, strconv.Itoa(metrics.ErrorNumber)}]++
+	}
+	m.retries[metrics.Endpoint] += uint64(metrics.Retries)
+
+	h := m.durations[metrics.Endpoint]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[metrics.Endpoint] = h
+	}
+	seconds := metrics.Duration.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	cw := &countingWriter{w: buf}
+
+	fmt.Fprintln(cw, "# HELP vpp_requests_total Requests sent to the VPP service, by endpoint and HTTP status code.")
+	fmt.Fprintln(cw, "# TYPE vpp_requests_total counter")
+	for _, key := range sortedPairs(m.requests) {
+		fmt.Fprintf(cw, "vpp_requests_total{endpoint=\"%s\",code=\"%s\"} %d\n", escapeLabel(key[0]), key[1], m.requests[key])
+	}
+
+	fmt.Fprintln(cw, "# HELP vpp_errors_total Errors reported by the VPP service, by endpoint and VPP error number.")
+	fmt.Fprintln(cw, "# TYPE vpp_errors_total counter")
+	for _, key := range sortedPairs(m.vppErrors) {
+		fmt.Fprintf(cw, "vpp_errors_total{endpoint=\"%s\",error_number=\"%s\"} %d\n", escapeLabel(key[0]), key[1], m.vppErrors[key])
+	}
+
+	endpoints := make([]string, 0, len(m.durations))
+	for endpoint := range m.durations {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	fmt.Fprintln(cw, "# HELP vpp_retries_total Requests replayed after the VPP service asked for them to be retried later.")
+	fmt.Fprintln(cw, "# TYPE vpp_retries_total counter")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(cw, "vpp_retries_total{endpoint=\"%s\"} %d\n", escapeLabel(endpoint), m.retries[endpoint])
+	}
+
+	fmt.Fprintln(cw, "# HELP vpp_request_duration_seconds Time taken by requests to the VPP service, including retries.")
+	fmt.Fprintln(cw, "# TYPE vpp_request_duration_seconds histogram")
+	for _, endpoint := range endpoints {
+		h := m.durations[endpoint]
+		label := escapeLabel(endpoint)
+		for i, bound := range m.buckets {
+			fmt.Fprintf(cw, "vpp_request_duration_seconds_bucket{endpoint=\"%s\",le=\"%s\"} %d\n", label, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(cw, "vpp_request_duration_seconds_bucket{endpoint=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(cw, "vpp_request_duration_seconds_sum{endpoint=\"%s\"} %s\n", label, formatFloat(h.sum))
+		fmt.Fprintf(cw, "vpp_request_duration_seconds_count{endpoint=\"%s\"} %d\n", label, h.count)
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, buf.Flush()
+}
+
+// ServeHTTP serves the metrics, so that they can be scraped by Prometheus.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// countingWriter counts the bytes written and keeps the first error, so that it can be used with fmt.Fprintf.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,100 @@
+package vpp
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mosen/vpp/vpptest"
+)
+
+type recordingInstrumentation struct {
+	mu       sync.Mutex
+	requests []RequestMetrics
+}
+
+func (r *recordingInstrumentation) ObserveRequest(metrics RequestMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, metrics)
+}
+
+func TestVppClient_Do_Instrumentation(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppSim.Play(&vpptest.Scenario{Responses: map[string][]vpptest.ResponseFault{
+		"getVPPUsersSrv": {
+			{Count: 1, Response: vpptest.ResponseServiceUnavailable, RetryAfter: "0"},
+			{Count: 1, After: 1, Response: vpptest.ResponseVPPError, ErrorNumber: ErrorNumberInternalError},
+		},
+	}})
+
+	recorder := &recordingInstrumentation{}
+	metrics := NewPrometheusMetrics()
+	vppClient, err := NewVPPClient(config, WithInstrumentation(recorder), WithInstrumentation(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err == nil {
+		t.Fatal("expected a VPP error")
+	}
+
+	var users []RequestMetrics
+	for _, request := range recorder.requests {
+		if request.Endpoint == "getVPPUsersSrv" {
+			users = append(users, request)
+		}
+	}
+	if len(users) != 2 {
+		t.Fatalf("expected 2 requests to getVPPUsersSrv, got %#v", recorder.requests)
+	}
+	if users[0].Retries != 1 || users[0].StatusCode != 200 || users[0].ErrorNumber != 0 || users[0].Err != nil {
+		t.Errorf("expected a successful request after a retry, got %#v", users[0])
+	}
+	if users[1].ErrorNumber != ErrorNumberInternalError || users[1].Err != nil || users[1].Method != "POST" {
+		t.Errorf("expected a request reporting a VPP error, got %#v", users[1])
+	}
+
+	var buf bytes.Buffer
+	if _, err := metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`vpp_requests_total{endpoint="getVPPUsersSrv",code="200"} 2`,
+		`vpp_requests_total{endpoint="VPPServiceConfigSrv",code="200"} 1`,
+		`vpp_errors_total{endpoint="getVPPUsersSrv",error_number="9603"} 1`,
+		`vpp_retries_total{endpoint="getVPPUsersSrv"} 1`,
+		`vpp_request_duration_seconds_bucket{endpoint="getVPPUsersSrv",le="+Inf"} 2`,
+		`vpp_request_duration_seconds_count{endpoint="getVPPUsersSrv"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestPrometheusMetrics_Buckets(t *testing.T) {
+	metrics := NewPrometheusMetrics(1, 0.1)
+	metrics.ObserveRequest(RequestMetrics{Endpoint: `odd"endpoint`, Duration: 500 * time.Millisecond})
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	for _, line := range []string{
+		`vpp_requests_total{endpoint="odd\"endpoint",code="none"} 1`,
+		`vpp_request_duration_seconds_bucket{endpoint="odd\"endpoint",le="0.1"} 0`,
+		`vpp_request_duration_seconds_bucket{endpoint="odd\"endpoint",le="1"} 1`,
+		`vpp_request_duration_seconds_sum{endpoint="odd\"endpoint"} 0.5`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, buf.String())
+		}
+	}
+}
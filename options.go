@@ -0,0 +1,252 @@
+package vpp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type clientOpts struct {
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    time.Duration
+	userAgent  string
+	baseURL    *url.URL
+
+	serviceConfig    *ServiceConfig
+	serviceConfigTTL time.Duration
+
+	tokenProvider TokenProvider
+
+	logger    Logger
+	logBodies bool
+
+	rateLimit          *RateLimit
+	endpointRateLimits map[string]RateLimit
+
+	instrumentation []Instrumentation
+
+	lookupURL     *url.URL
+	lookupCountry string
+}
+
+// ClientOption describes the signature of the closure returned by a function adding an option to NewVPPClient
+type ClientOption func(*clientOpts) error
+
+// WithHTTPClient makes the client send requests using the given http.Client instead of http.DefaultClient.
+// The given client is not modified by WithTransport or WithTimeout, which apply to a copy of it.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(opts *clientOpts) error {
+		if client == nil {
+			return errors.New("no http client given")
+		}
+		opts.httpClient = client
+		return nil
+	}
+}
+
+// WithTransport sets the http.RoundTripper used to send requests, eg. to configure proxies, mTLS or instrumentation.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(opts *clientOpts) error {
+		if transport == nil {
+			return errors.New("no transport given")
+		}
+		opts.transport = transport
+		return nil
+	}
+}
+
+// WithTimeout limits the time taken by each HTTP request, including reading the response body.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(opts *clientOpts) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be greater than zero")
+		}
+		opts.timeout = timeout
+		return nil
+	}
+}
+
+// WithUserAgent identifies your product to the VPP service, as Apple recommends.
+// The product is prepended to the User-Agent of this library, eg. "MyMDM/1.2 micromdm/0.0.1".
+func WithUserAgent(product string) ClientOption {
+	return func(opts *clientOpts) error {
+		if product == "" {
+			return errors.New("no user agent given")
+		}
+		opts.userAgent = product + " " + userAgent
+		return nil
+	}
+}
+
+// WithBaseURL sets the base URL that the service configuration is requested from, overriding Config.URL.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(opts *clientOpts) error {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() {
+			return errors.New("base url must be absolute")
+		}
+		opts.baseURL = u
+		return nil
+	}
+}
+
+// WithServiceConfig seeds the client with a service configuration, eg. one persisted by a previous process, so that
+// the client can be used without contacting the VPP service first. The configuration is refreshed once it is older
+// than the TTL.
+func WithServiceConfig(serviceConfig *ServiceConfig) ClientOption {
+	return func(opts *clientOpts) error {
+		if serviceConfig == nil {
+			return errors.New("no service config given")
+		}
+		opts.serviceConfig = serviceConfig
+		return nil
+	}
+}
+
+// WithServiceConfigTTL sets how long the service configuration is cached for. The default is DefaultServiceConfigTTL.
+func WithServiceConfigTTL(ttl time.Duration) ClientOption {
+	return func(opts *clientOpts) error {
+		if ttl <= 0 {
+			return errors.New("service config ttl must be greater than zero")
+		}
+		opts.serviceConfigTTL = ttl
+		return nil
+	}
+}
+
+// newHTTPClient builds the http.Client described by the options.
+func (opts *clientOpts) newHTTPClient() *http.Client {
+	client := http.DefaultClient
+	if opts.httpClient != nil {
+		client = opts.httpClient
+	}
+
+	if opts.transport == nil && opts.timeout == 0 {
+		return client
+	}
+
+	configured := *client
+	if opts.transport != nil {
+		configured.Transport = opts.transport
+	}
+	if opts.timeout != 0 {
+		configured.Timeout = opts.timeout
+	}
+	return &configured
+}
+
+// WithTokenProvider makes the client ask the given provider for the sToken of each request, instead of using
+// Config.SToken.
+func WithTokenProvider(provider TokenProvider) ClientOption {
+	return func(opts *clientOpts) error {
+		if provider == nil {
+			return errors.New("no token provider given")
+		}
+		opts.tokenProvider = provider
+		return nil
+	}
+}
+
+// WithLogger makes the client log the method, endpoint, status, latency and retries of each request to the given
+// logger, such as a *slog.Logger.
+func WithLogger(logger Logger) ClientOption {
+	return func(opts *clientOpts) error {
+		if logger == nil {
+			return errors.New("no logger given")
+		}
+		opts.logger = logger
+		return nil
+	}
+}
+
+// WithBodyLogging makes the client also log request and response bodies at debug level. The sToken, email addresses
+// and invitation codes and URLs are redacted from the logged bodies.
+func WithBodyLogging() ClientOption {
+	return func(opts *clientOpts) error {
+		opts.logBodies = true
+		return nil
+	}
+}
+
+// WithRateLimit limits the rate of all requests sent by the client, shared by every goroutine using it. Requests wait
+// until they are allowed, or fail if the deadline of their context would pass first.
+// The rate is slowed down further while the VPP service responds with Retry-After headers.
+//
+// Regardless of any rate limit, a Retry-After from any endpoint pauses requests to every endpoint until it has passed,
+// since the VPP service uses it to report that the client as a whole is causing too much load.
+func WithRateLimit(rate float64, burst int) ClientOption {
+	return func(opts *clientOpts) error {
+		limit := RateLimit{Rate: rate, Burst: burst}
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		opts.rateLimit = &limit
+		return nil
+	}
+}
+
+// WithEndpointRateLimit limits the rate of requests to a single endpoint of the VPP service, eg. "getVPPAssetsSrv",
+// in addition to any limit set with WithRateLimit.
+func WithEndpointRateLimit(endpoint string, rate float64, burst int) ClientOption {
+	return func(opts *clientOpts) error {
+		if endpoint == "" {
+			return errors.New("no endpoint given")
+		}
+		limit := RateLimit{Rate: rate, Burst: burst}
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		if opts.endpointRateLimits == nil {
+			opts.endpointRateLimits = make(map[string]RateLimit)
+		}
+		opts.endpointRateLimits[endpoint] = limit
+		return nil
+	}
+}
+
+// WithInstrumentation makes the client report every request to the given hook, eg. a *PrometheusMetrics.
+// It may be given more than once to report to several hooks.
+func WithInstrumentation(instrumentation Instrumentation) ClientOption {
+	return func(opts *clientOpts) error {
+		if instrumentation == nil {
+			return errors.New("no instrumentation given")
+		}
+		opts.instrumentation = append(opts.instrumentation, instrumentation)
+		return nil
+	}
+}
+
+// WithLookupURL sets the URL of the iTunes lookup style endpoint used to find the store listings of assets, eg. the
+// URL of a vpptest.Server.
+func WithLookupURL(rawurl string) ClientOption {
+	return func(opts *clientOpts) error {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() {
+			return errors.New("lookup url must be absolute")
+		}
+		opts.lookupURL = u
+		return nil
+	}
+}
+
+// WithLookupCountry sets the two letter code of the store country in which assets are looked up, eg. the country
+// code returned with the client context. The lookup endpoint uses the US store by default.
+func WithLookupCountry(countryCode string) ClientOption {
+	return func(opts *clientOpts) error {
+		if len(countryCode) != 2 {
+			return fmt.Errorf("invalid country code %q", countryCode)
+		}
+		opts.lookupCountry = strings.ToLower(countryCode)
+		return nil
+	}
+}
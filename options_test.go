@@ -0,0 +1,94 @@
+package vpp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNewVPPClient_Options(t *testing.T) {
+	var gotUserAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserAgent = r.Header.Get("User-Agent")
+		w.Write([]byte(`{"status":0}`))
+	}))
+	defer srv.Close()
+
+	var transported bool
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		transported = true
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	httpClient := &http.Client{}
+	config := &Config{SToken: "token"}
+	client, err := NewVPPClient(config,
+		WithHTTPClient(httpClient),
+		WithTransport(transport),
+		WithTimeout(5*time.Second),
+		WithUserAgent("ExampleMDM/1.0"),
+		WithBaseURL(srv.URL),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.ServiceConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := client.(*vppClient)
+	if c.client == httpClient || httpClient.Transport != nil || httpClient.Timeout != 0 {
+		t.Error("expected the given http client not to be modified")
+	}
+
+	if c.client.Timeout != 5*time.Second {
+		t.Errorf("expected timeout to be set, got %s", c.client.Timeout)
+	}
+
+	if !transported {
+		t.Error("expected the service config request to go through the transport")
+	}
+
+	if gotUserAgent != "ExampleMDM/1.0 "+userAgent {
+		t.Errorf("unexpected User-Agent %q", gotUserAgent)
+	}
+
+	if config.URL != nil {
+		t.Errorf("expected the given config not to be modified, got URL %s", config.URL)
+	}
+	other, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := other.(*vppClient).BaseURL.String(); base != defaultBaseURL {
+		t.Errorf("expected a client sharing the config to use the default URL, got %s", base)
+	}
+}
+
+func TestNewVPPClient_InvalidOptions(t *testing.T) {
+	invalid := []ClientOption{
+		WithHTTPClient(nil),
+		WithTransport(nil),
+		WithTimeout(0),
+		WithUserAgent(""),
+		WithBaseURL("not/absolute"),
+		WithServiceConfig(nil),
+		WithServiceConfigTTL(0),
+		WithLookupURL("/lookup"),
+		WithLookupCountry("Australia"),
+	}
+
+	for _, option := range invalid {
+		if _, err := NewVPPClient(&Config{}, option); err == nil {
+			t.Error("expected an error from an invalid option")
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// throttleRecoveryInterval is how long the client must go without being asked to retry before a rate slowed down by
+// Retry-After responses is doubled again, until it is back to the configured rate.
+const throttleRecoveryInterval = time.Minute
+
+// minThrottleFactor is the smallest fraction of the configured rate that the client slows down to.
+const minThrottleFactor = 1.0 / 16
+
+// RateLimit describes a token bucket. Requests are allowed at Rate per second on average, in bursts of up to Burst.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) validate() error {
+	if l.Rate <= 0 {
+		return errors.New("rate limit must be greater than zero")
+	}
+	if l.Burst < 1 {
+		return errors.New("rate limit burst must be at least one")
+	}
+	return nil
+}
+
+// tokenBucket limits the rate of requests, and slows down further while the VPP service asks for requests to be
+// retried later.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+
+	factor    float64
+	throttled time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), factor: 1}
+}
+
+// reserve takes a token, returning how long the caller must wait before sending its request.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.factor < 1 && now.Sub(b.throttled) >= throttleRecoveryInterval {
+		b.factor *= 2
+		if b.factor > 1 {
+			b.factor = 1
+		}
+		b.throttled = now
+	}
+
+	rate := b.limit.Rate * b.factor
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if burst := float64(b.limit.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// cancel returns a token taken by a caller which gave up waiting for it.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+}
+
+// throttle halves the rate of the bucket.
+func (b *tokenBucket) throttle(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.factor /= 2
+	if b.factor < minThrottleFactor {
+		b.factor = minThrottleFactor
+	}
+	b.throttled = now
+}
+
+// rateLimiter holds the rate limits of a client, shared by every goroutine using the client.
+// Every client has one, even without rate limits, so that a Retry-After pauses all of its requests.
+type rateLimiter struct {
+	global    *tokenBucket
+	endpoints map[string]*tokenBucket
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+func newRateLimiter(global *RateLimit, endpoints map[string]RateLimit) *rateLimiter {
+	l := &rateLimiter{endpoints: make(map[string]*tokenBucket)}
+	if global != nil {
+		l.global = newTokenBucket(*global)
+	}
+	for endpoint, limit := range endpoints {
+		l.endpoints[endpoint] = newTokenBucket(limit)
+	}
+	return l
+}
+
+// wait blocks until a request to the endpoint is allowed, or returns an error if the context is done first or its
+// deadline would pass.
+func (l *rateLimiter) wait(ctx context.Context, endpoint string) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	delay := l.pausedUntil.Sub(now)
+	l.mu.Unlock()
+
+	var taken []*tokenBucket
+	for _, bucket := range []*tokenBucket{l.global, l.endpoints[endpoint]} {
+		if bucket == nil {
+			continue
+		}
+		taken = append(taken, bucket)
+		if d := bucket.reserve(now); d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+	if err := sleepContext(ctx, delay); err != nil {
+		for _, bucket := range taken {
+			bucket.cancel()
+		}
+		return err
+	}
+	return nil
+}
+
+// throttled records that the VPP service asked for requests to the endpoint to be retried after the given delay.
+// Every request, to any endpoint, waits until then, and the rate of requests is slowed down until the service recovers.
+func (l *rateLimiter) throttled(endpoint string, retryAfter time.Duration) {
+	if l == nil {
+		return
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	if until := now.Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	l.mu.Unlock()
+
+	if l.global != nil {
+		l.global.throttle(now)
+	}
+	if bucket := l.endpoints[endpoint]; bucket != nil {
+		bucket.throttle(now)
+	}
+}
@@ -0,0 +1,118 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 2})
+
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait := b.reserve(now); wait != expected {
+			t.Errorf("reservation %d: expected to wait %s, got %s", i, expected, wait)
+		}
+	}
+
+	// After a second the bucket has refilled, but only up to its burst.
+	now = now.Add(time.Second)
+	for i, expected := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		if wait := b.reserve(now); wait != expected {
+			t.Errorf("reservation %d after refill: expected to wait %s, got %s", i, expected, wait)
+		}
+	}
+}
+
+func TestTokenBucket_Throttle(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 1})
+
+	b.reserve(now)
+	b.throttle(now)
+	if wait := b.reserve(now); wait != 200*time.Millisecond {
+		t.Errorf("expected the rate to be halved, waiting %s", wait)
+	}
+
+	// The rate recovers once the service has stopped asking for retries.
+	now = now.Add(throttleRecoveryInterval)
+	b.reserve(now)
+	if wait := b.reserve(now); wait != 100*time.Millisecond {
+		t.Errorf("expected the rate to recover, waiting %s", wait)
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	l := newRateLimiter(&RateLimit{Rate: 20, Burst: 1}, map[string]RateLimit{"getVPPAssetsSrv": {Rate: 1, Burst: 1}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background(), "getVPPUsersSrv"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected the global limit to space out requests, took %s", elapsed)
+	}
+
+	if err := l.wait(context.Background(), "getVPPAssetsSrv"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, "getVPPAssetsSrv"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the endpoint limit to exceed the deadline, got %v", err)
+	}
+}
+
+func TestVppClient_Do_RetryAfterPausesOtherRequests(t *testing.T) {
+	var mu sync.Mutex
+	busy := true
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if busy {
+			busy = false
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":0}`))
+	}))
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{}, limiter: newRateLimiter(nil, nil)}
+	if err := c.Do(mustRequest(t, c, srv.URL), &getVPPUsersSrvResponse{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request from another goroutine made right after the 503 would also have waited.
+	c.limiter.throttled("getVPPUsersSrv", 200*time.Millisecond)
+	start := time.Now()
+	if err := c.Do(mustRequest(t, c, srv.URL), &getVPPUsersSrvResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the request to wait for the pause, took %s", elapsed)
+	}
+
+	if len(times) != 3 || times[1].Sub(times[0]) < time.Second {
+		t.Errorf("expected the retry to wait for the Retry-After delay, requests at %v", times)
+	}
+}
+
+func mustRequest(t *testing.T, c *vppClient, url string) *http.Request {
+	req, err := c.NewRequest("POST", url+"/getVPPUsersSrv", &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
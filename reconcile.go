@@ -0,0 +1,408 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DesiredLicenses lists the devices and users which should hold a license of an asset.
+type DesiredLicenses struct {
+	// PricingParam selects the quality of the licenses, PricingParamStd if it is empty.
+	PricingParam  PricingParam
+	SerialNumbers []string
+	ClientUserIDs []string
+}
+
+// DesiredState maps the adam ids of assets to the devices and users which should hold their licenses.
+// Licenses of the listed assets held by any other device or user are revoked. Assets which are not listed are left
+// as they are.
+type DesiredState map[string]DesiredLicenses
+
+// PlanAction is the change a PlanStep makes to a license.
+type PlanAction int
+
+const (
+	PlanNoop PlanAction = iota
+	PlanAssign
+	PlanRevoke
+	PlanBlocked
+)
+
+func (a PlanAction) String() string {
+	switch a {
+	case PlanNoop:
+		return "no-op"
+	case PlanAssign:
+		return "assign"
+	case PlanRevoke:
+		return "revoke"
+	case PlanBlocked:
+		return "blocked"
+	default:
+		return fmt.Sprintf("PlanAction(%d)", int(a))
+	}
+}
+
+// PlanStep is a single change, or lack of one, to the license of a device or user.
+// Exactly one of SerialNumber and ClientUserID is set, unless the license is held by a user known only by user id.
+type PlanStep struct {
+	Action       PlanAction
+	AdamID       string
+	PricingParam PricingParam
+	SerialNumber string
+	ClientUserID string
+	UserID       int
+
+	// LicenseID is the license which is kept or revoked, or which cannot be revoked.
+	LicenseID string
+
+	// Blocked describes why the step cannot be applied. It is only set if Action is PlanBlocked.
+	Blocked string
+}
+
+// holder describes the device or user that the step concerns.
+func (s PlanStep) holder() string {
+	switch {
+	case s.SerialNumber != "":
+		return "serial number " + s.SerialNumber
+	case s.ClientUserID != "":
+		return "user " + s.ClientUserID
+	default:
+		return "user id " + strconv.Itoa(s.UserID)
+	}
+}
+
+func (s PlanStep) String() string {
+	switch s.Action {
+	case PlanAssign:
+		return fmt.Sprintf("+ assign to %s", s.holder())
+	case PlanRevoke:
+		return fmt.Sprintf("- revoke license %s from %s", s.LicenseID, s.holder())
+	case PlanBlocked:
+		return fmt.Sprintf("! cannot change %s: %s", s.holder(), s.Blocked)
+	default:
+		return fmt.Sprintf("  keep license %s on %s", s.LicenseID, s.holder())
+	}
+}
+
+// Plan lists the changes needed to reach a DesiredState, grouped by asset.
+type Plan struct {
+	Steps []PlanStep
+}
+
+// Count returns the number of steps taking the given action.
+func (p *Plan) Count(action PlanAction) int {
+	var count int
+	for _, step := range p.Steps {
+		if step.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// String describes the plan for review before it is applied.
+func (p *Plan) String() string {
+	var b strings.Builder
+	var asset string
+	for _, step := range p.Steps {
+		if key := step.AdamID + " (" + string(step.PricingParam) + ")"; key != asset {
+			asset = key
+			fmt.Fprintf(&b, "%s:\n", asset)
+		}
+		fmt.Fprintf(&b, "  %s\n", step)
+	}
+
+	fmt.Fprintf(&b, "Plan: %d to assign, %d to revoke, %d unchanged, %d blocked.\n",
+		p.Count(PlanAssign), p.Count(PlanRevoke), p.Count(PlanNoop), p.Count(PlanBlocked))
+	return b.String()
+}
+
+// Reconciler compares the licenses of a VPP account with a DesiredState, and assigns and revokes licenses to reach
+// it.
+//
+//	reconciler := vpp.NewReconciler(client)
+//	plan, err := reconciler.Plan(ctx, vpp.DesiredState{
+//		"408709785": {SerialNumbers: serialNumbers},
+//	})
+//	fmt.Print(plan)
+//	result, err := reconciler.Apply(ctx, plan, false)
+type Reconciler struct {
+	client VPPClient
+}
+
+// NewReconciler creates a reconciler for the licenses of the given client.
+func NewReconciler(client VPPClient) *Reconciler {
+	return &Reconciler{client: client}
+}
+
+// Plan compares the licenses of each asset in the desired state with the devices and users which should hold them.
+// Assignments are blocked when the account owns no licenses of the asset, when devices are listed for an asset that
+// cannot be assigned to devices, or when there are not enough licenses available, counting the licenses the plan
+// revokes. Revocations are blocked when the license is irrevocable.
+func (r *Reconciler) Plan(ctx context.Context, desired DesiredState) (*Plan, error) {
+	assets, err := r.client.GetAssetsWithContext(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	adamIDs := make([]string, 0, len(desired))
+	for adamID := range desired {
+		adamIDs = append(adamIDs, adamID)
+	}
+	sort.Strings(adamIDs)
+
+	plan := &Plan{}
+	for _, adamID := range adamIDs {
+		steps, err := r.planAsset(ctx, adamID, desired[adamID], assets)
+		if err != nil {
+			return nil, err
+		}
+		plan.Steps = append(plan.Steps, steps...)
+	}
+	return plan, nil
+}
+
+func (r *Reconciler) planAsset(ctx context.Context, adamID string, desired DesiredLicenses, assets Assets) ([]PlanStep, error) {
+	pricingParam := desired.PricingParam
+	if pricingParam == "" {
+		pricingParam = PricingParamStd
+	}
+
+	wantSerials := make(map[string]bool)
+	for _, serialNumber := range desired.SerialNumbers {
+		wantSerials[serialNumber] = true
+	}
+	wantUsers := make(map[string]bool)
+	for _, clientUserID := range desired.ClientUserIDs {
+		wantUsers[clientUserID] = true
+	}
+
+	var keep, revoke []PlanStep
+	it := r.client.IterateLicenses(ctx, nil, ForAdamID(adamID), WithPricingParam(pricingParam))
+	for it.Next() {
+		license := it.License()
+		if !license.Assigned() {
+			continue
+		}
+
+		step := PlanStep{AdamID: adamID, PricingParam: pricingParam, LicenseID: license.LicenseID, SerialNumber: license.SerialNumber}
+		if step.SerialNumber == "" && license.VPPUser != nil {
+			step.ClientUserID = license.VPPUser.ClientUserIdStr
+			step.UserID = license.VPPUser.UserID
+		}
+
+		switch {
+		case step.SerialNumber != "" && wantSerials[step.SerialNumber]:
+			delete(wantSerials, step.SerialNumber)
+			keep = append(keep, step)
+		case step.ClientUserID != "" && wantUsers[step.ClientUserID]:
+			delete(wantUsers, step.ClientUserID)
+			keep = append(keep, step)
+		case license.IsIrrevocable:
+			step.Action = PlanBlocked
+			step.Blocked = fmt.Sprintf("license %s is irrevocable", license.LicenseID)
+			revoke = append(revoke, step)
+		default:
+			step.Action = PlanRevoke
+			revoke = append(revoke, step)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	var owned *VPPAssetAssignment
+	for i := range assets {
+		if assets[i].AdamIdStr == adamID && assets[i].PricingParam == pricingParam {
+			owned = &assets[i]
+		}
+	}
+
+	// Licenses which are revoked by the plan can be assigned again.
+	var available int
+	if owned != nil {
+		available = owned.AvailableCount
+	}
+	for _, step := range revoke {
+		if step.Action == PlanRevoke {
+			available++
+		}
+	}
+	remaining := available
+
+	var assign []PlanStep
+	for _, serialNumber := range sortedKeys(wantSerials) {
+		assign = append(assign, PlanStep{Action: PlanAssign, AdamID: adamID, PricingParam: pricingParam, SerialNumber: serialNumber})
+	}
+	for _, clientUserID := range sortedKeys(wantUsers) {
+		assign = append(assign, PlanStep{Action: PlanAssign, AdamID: adamID, PricingParam: pricingParam, ClientUserID: clientUserID})
+	}
+	for i := range assign {
+		switch {
+		case owned == nil:
+			assign[i].Blocked = "asset not owned by this account"
+		case assign[i].SerialNumber != "" && !owned.DeviceAssignable:
+			assign[i].Blocked = "asset cannot be assigned to devices"
+		case remaining <= 0:
+			assign[i].Blocked = fmt.Sprintf("only %d licenses are available", available)
+		default:
+			remaining--
+			continue
+		}
+		assign[i].Action = PlanBlocked
+	}
+
+	steps := append(revoke, assign...)
+	return append(steps, keep...), nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// StepError reports a step of a plan which could not be applied.
+type StepError struct {
+	Step PlanStep
+	Err  error
+}
+
+func (e StepError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Step, e.Err)
+}
+
+func (e StepError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyResult describes the outcome of applying a plan.
+type ApplyResult struct {
+	Applied []PlanStep
+	Failed  []StepError
+}
+
+// Apply assigns and revokes the licenses of a plan with batched manageVPPLicensesByAdamIdSrv requests. The licenses
+// of each asset are revoked before any are assigned, so that they can be reassigned. Blocked and no-op steps are
+// skipped. The notify argument controls whether users are notified of revocations.
+// Apply carries on after a step fails, returning an error along with the result if any step failed.
+func (r *Reconciler) Apply(ctx context.Context, plan *Plan, notify bool) (*ApplyResult, error) {
+	result := &ApplyResult{}
+
+	type assetKey struct {
+		adamID       string
+		pricingParam PricingParam
+	}
+	var order []assetKey
+	byAsset := make(map[assetKey][]PlanStep)
+	for _, step := range plan.Steps {
+		if step.Action != PlanAssign && step.Action != PlanRevoke {
+			continue
+		}
+		key := assetKey{step.AdamID, step.PricingParam}
+		if _, ok := byAsset[key]; !ok {
+			order = append(order, key)
+		}
+		byAsset[key] = append(byAsset[key], step)
+	}
+
+	for _, key := range order {
+		asset := &VPPAsset{AdamID: key.adamID, PricingParam: key.pricingParam}
+		revocations := NewLicenseOperations(asset)
+		devices := NewLicenseOperations(asset)
+		users := NewLicenseOperations(asset)
+
+		var revoke, assignDevices, assignUsers []PlanStep
+		for _, step := range byAsset[key] {
+			switch {
+			case step.Action == PlanRevoke:
+				revocations.UnassignLicenseID(step.LicenseID)
+				revoke = append(revoke, step)
+			case step.SerialNumber != "":
+				devices.AssignSerialNumber(step.SerialNumber)
+				assignDevices = append(assignDevices, step)
+			default:
+				users.AssignUser(&VPPUser{ClientUserIdStr: step.ClientUserID})
+				assignUsers = append(assignUsers, step)
+			}
+		}
+
+		r.apply(ctx, revocations, revoke, notify, result)
+		r.apply(ctx, devices, assignDevices, notify, result)
+		r.apply(ctx, users, assignUsers, notify, result)
+	}
+
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("%d of %d plan steps failed, first error: %v",
+			len(result.Failed), len(result.Failed)+len(result.Applied), result.Failed[0])
+	}
+	return result, nil
+}
+
+// apply submits the operations of the given steps, recording the outcome of each step in the result.
+func (r *Reconciler) apply(ctx context.Context, ops *LicenseOperations, steps []PlanStep, notify bool, result *ApplyResult) {
+	if len(steps) == 0 {
+		return
+	}
+
+	managed, err := r.client.ManageLicensesWithContext(ctx, ops, notify)
+	var chunksErr *ManageLicensesError
+	if err != nil && !errors.As(err, &chunksErr) {
+		for _, step := range steps {
+			result.Failed = append(result.Failed, StepError{Step: step, Err: err})
+		}
+		return
+	}
+
+	failures := make(map[string]error)
+	if chunksErr != nil {
+		for _, chunk := range chunksErr.Chunks {
+			for _, id := range chunk.Operations.DisassociateLicenseIDs {
+				failures["license:"+id] = chunk.Err
+			}
+			for _, serialNumber := range chunk.Operations.AssociateSerialNumbers {
+				failures["serial:"+serialNumber] = chunk.Err
+			}
+			for _, user := range chunk.Operations.AssociateUsers {
+				failures["user:"+user.ClientUserIdStr] = chunk.Err
+			}
+		}
+	}
+	if managed != nil {
+		for _, association := range managed.Failed() {
+			err := error(association.VPPError)
+			switch {
+			case association.LicenseIDStr != "" && len(ops.DisassociateLicenseIDs) > 0:
+				failures["license:"+association.LicenseIDStr] = err
+			case association.SerialNumber != "":
+				failures["serial:"+association.SerialNumber] = err
+			case association.ClientUserIDStr != "":
+				failures["user:"+association.ClientUserIDStr] = err
+			}
+		}
+	}
+
+	for _, step := range steps {
+		key := "user:" + step.ClientUserID
+		switch {
+		case step.Action == PlanRevoke:
+			key = "license:" + step.LicenseID
+		case step.SerialNumber != "":
+			key = "serial:" + step.SerialNumber
+		}
+
+		if err, ok := failures[key]; ok {
+			result.Failed = append(result.Failed, StepError{Step: step, Err: err})
+		} else {
+			result.Applied = append(result.Applied, step)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package vpp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mosen/vpp/vpptest"
+)
+
+func TestReconciler_PlanApply(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726", IsIrrevocable: true, DeviceAssignable: true}, 1)
+	for adamID, serialNumbers := range map[string][]string{
+		"408709785": {"C02AAAAAAAAA", "C02BBBBBBBBB"},
+		"361309726": {"C02XXXXXXXXX"},
+	} {
+		ops := NewLicenseOperations(&VPPAsset{AdamID: adamID})
+		for _, serialNumber := range serialNumbers {
+			ops.AssignSerialNumber(serialNumber)
+		}
+		if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reconciler := NewReconciler(vppClient)
+	desired := DesiredState{
+		"408709785": {SerialNumbers: []string{"C02BBBBBBBBB", "C02CCCCCCCCC"}, ClientUserIDs: []string{clientIdStrFixture}},
+		"361309726": {SerialNumbers: []string{"C02YYYYYYYYY"}},
+	}
+	plan, err := reconciler.Plan(context.Background(), desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.Count(PlanAssign) != 2 || plan.Count(PlanRevoke) != 1 || plan.Count(PlanNoop) != 1 || plan.Count(PlanBlocked) != 2 {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+	for _, want := range []string{
+		"361309726 (STDQ):\n",
+		"! cannot change serial number C02XXXXXXXXX: license 11 is irrevocable",
+		"! cannot change serial number C02YYYYYYYYY: only 0 licenses are available",
+		"- revoke license 1 from serial number C02AAAAAAAAA",
+		"+ assign to serial number C02CCCCCCCCC",
+		"+ assign to user " + clientIdStrFixture,
+		"  keep license 2 on serial number C02BBBBBBBBB",
+		"Plan: 2 to assign, 1 to revoke, 1 unchanged, 2 blocked.",
+	} {
+		if !strings.Contains(plan.String(), want) {
+			t.Errorf("expected the plan to contain %q, got:\n%s", want, plan)
+		}
+	}
+
+	result, err := reconciler.Apply(context.Background(), plan, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 3 || len(result.Failed) != 0 {
+		t.Errorf("expected 3 steps to be applied, got %#v", result)
+	}
+
+	plan, err = reconciler.Plan(context.Background(), desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count(PlanAssign) != 0 || plan.Count(PlanRevoke) != 0 || plan.Count(PlanNoop) != 3 {
+		t.Errorf("expected only the blocked steps to remain, got:\n%s", plan)
+	}
+}
+
+func TestReconciler_InsufficientLicenses(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var serialNumbers []string
+	for i := 0; i < 12; i++ {
+		serialNumbers = append(serialNumbers, fmt.Sprintf("C02%09d", i))
+	}
+
+	reconciler := NewReconciler(vppClient)
+	plan, err := reconciler.Plan(context.Background(), DesiredState{"408709785": {SerialNumbers: serialNumbers}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count(PlanAssign) != 10 || plan.Count(PlanBlocked) != 2 {
+		t.Fatalf("expected 10 of the 12 devices to be assigned, got:\n%s", plan)
+	}
+
+	// A license is taken before the plan is applied, so one of the assignments fails.
+	ops := NewLicenseOperations(&VPPAsset{AdamID: "408709785"})
+	ops.AssignSerialNumber("C02ZZZZZZZZZ")
+	if _, err := vppClient.ManageLicenses(ops, false); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := reconciler.Apply(context.Background(), plan, false)
+	if err == nil {
+		t.Fatal("expected an error when a step fails")
+	}
+	if len(result.Applied) != 9 || len(result.Failed) != 1 || result.Failed[0].Step.SerialNumber != "C02000000009" {
+		t.Errorf("expected the last assignment to fail, got %#v", result)
+	}
+}
+
+func TestReconciler_BlockedAssets(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "361309726"}, 2)
+
+	plan, err := NewReconciler(vppClient).Plan(context.Background(), DesiredState{
+		"361309726": {SerialNumbers: []string{"C02AAAAAAAAA"}, ClientUserIDs: []string{clientIdStrFixture}},
+		"999999999": {ClientUserIDs: []string{clientIdStrFixture}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.Count(PlanAssign) != 1 || plan.Count(PlanBlocked) != 2 {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+	for _, want := range []string{
+		"! cannot change serial number C02AAAAAAAAA: asset cannot be assigned to devices",
+		"+ assign to user " + clientIdStrFixture,
+		"999999999 (STDQ):\n  ! cannot change user " + clientIdStrFixture + ": asset not owned by this account",
+	} {
+		if !strings.Contains(plan.String(), want) {
+			t.Errorf("expected the plan to contain %q, got:\n%s", want, plan)
+		}
+	}
+}
@@ -4,15 +4,39 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
 )
 
+// sTokenExpDateLayouts lists the formats that the expDate of an sToken has been seen in.
+// Apple issues tokens in the first format, eg. 2018-03-21T15:13:18-0700
+var sTokenExpDateLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	time.RFC3339,
+}
+
+// SToken describes the decoded contents of a VPP service token, as downloaded from the VPP portal.
 type SToken struct {
 	Token      string `json:"token"`
 	ExpDateStr string `json:"expDate"`
 	OrgName    string `json:"orgName"`
+
+	// raw is the encoding that DecodeSToken was given, which Base64String returns while it still matches the
+	// exported fields.
+	raw string
 }
 
+// Base64String returns the token in the encoded form expected by the VPP service.
+// Tokens obtained through DecodeSToken are returned exactly as they were given, unless their fields were changed.
 func (t *SToken) Base64String() (string, error) {
+	if t.raw != "" {
+		if decoded, err := DecodeSToken(t.raw); err == nil && *decoded == *t {
+			return t.raw, nil
+		}
+	}
 
 	jsonValue, err := json.Marshal(t)
 	if err != nil {
@@ -27,13 +51,78 @@ func (t *SToken) Base64String() (string, error) {
 	return string(buf.Bytes()), nil
 }
 
+// ExpDate returns the time at which the token expires, or the zero time if ExpDateStr is not a valid expiry date.
+func (t *SToken) ExpDate() time.Time {
+	expDate, _ := parseSTokenExpDate(t.ExpDateStr)
+	return expDate
+}
+
+// Expired reports whether the token has passed its expiry date.
+func (t *SToken) Expired() bool {
+	return t.ExpiresWithin(0)
+}
+
+// ExpiresWithin reports whether the token will have expired by the time the given duration has elapsed.
+func (t *SToken) ExpiresWithin(d time.Duration) bool {
+	return !time.Now().Add(d).Before(t.ExpDate())
+}
+
+// DecodeSToken decodes and validates a base64 encoded sToken.
+// An error is returned if the token is not valid base64, is not valid JSON, or does not contain a token and a valid
+// expiry date. Expired tokens are not treated as an error, use Expired() to check for them.
 func DecodeSToken(base64str string) (*SToken, error) {
-	var jsonValue []byte
-	decoder := base64.NewDecoder(base64.StdEncoding, bytes.NewReader(jsonValue))
-	decoder.Read([]byte(base64str))
+	base64str = strings.TrimSpace(base64str)
+	if base64str == "" {
+		return nil, errors.New("sToken is empty")
+	}
+
+	jsonValue, err := base64.StdEncoding.DecodeString(base64str)
+	if err != nil {
+		return nil, fmt.Errorf("sToken is not valid base64: %w", err)
+	}
 
 	token := &SToken{}
-	json.Unmarshal(jsonValue, token)
+	if err := json.Unmarshal(jsonValue, token); err != nil {
+		return nil, fmt.Errorf("sToken is not valid JSON: %w", err)
+	}
+
+	if token.Token == "" {
+		return nil, errors.New("sToken does not contain a token")
+	}
+
+	if _, err := parseSTokenExpDate(token.ExpDateStr); err != nil {
+		return nil, err
+	}
+	token.raw = base64str
 
 	return token, nil
 }
+
+// LoadSTokenFile reads and validates an sToken file, as downloaded from Apple Business Manager or Apple School
+// Manager.
+func LoadSTokenFile(path string) (*SToken, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sToken, err := DecodeSToken(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sToken, nil
+}
+
+func parseSTokenExpDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("sToken does not contain an expiry date")
+	}
+
+	for _, layout := range sTokenExpDateLayouts {
+		if expDate, err := time.Parse(layout, value); err == nil {
+			return expDate, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("sToken expiry date %q is not in a recognised format", value)
+}
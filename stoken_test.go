@@ -1,17 +1,152 @@
 package vpp
 
-//import (
-//	"testing"
-//	"fmt"
-//)
-
-//func TestSToken_Base64String(t *testing.T) {
-//	setup()
-//	defer teardown()
-//
-//	encoded, err := config.SToken.Base64String()
-//	if err != nil {
-//		t.Error(err)
-//	}
-//	fmt.Printf("sToken encoded: %s\n", encoded)
-//}
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func encodeTestSToken(json string) string {
+	return base64.StdEncoding.EncodeToString([]byte(json))
+}
+
+func TestDecodeSToken(t *testing.T) {
+	encoded := encodeTestSToken(`{"token":"abc123","expDate":"2018-03-21T15:13:18-0700","orgName":"Example Inc."}`)
+
+	token, err := DecodeSToken(encoded + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Token != "abc123" || token.OrgName != "Example Inc." {
+		t.Errorf("unexpected token contents: %#v", token)
+	}
+
+	expected := time.Date(2018, 3, 21, 22, 13, 18, 0, time.UTC)
+	if !token.ExpDate().Equal(expected) {
+		t.Errorf("expected expiry date %s, got %s", expected, token.ExpDate())
+	}
+
+	if !token.Expired() {
+		t.Error("expected token to be expired")
+	}
+
+	reencoded, err := token.Base64String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reencoded != encoded {
+		t.Errorf("expected the original encoding to be preserved, got %s", reencoded)
+	}
+}
+
+func TestDecodeSToken_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":          "",
+		"not base64":     "not*base64",
+		"not json":       encodeTestSToken("token"),
+		"missing token":  encodeTestSToken(`{"expDate":"2018-03-21T15:13:18-0700"}`),
+		"missing expiry": encodeTestSToken(`{"token":"abc123"}`),
+		"bad expiry":     encodeTestSToken(`{"token":"abc123","expDate":"next tuesday"}`),
+	}
+
+	for name, encoded := range tests {
+		if _, err := DecodeSToken(encoded); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSToken_ExpiresWithin(t *testing.T) {
+	expDate := time.Now().Add(48 * time.Hour).Format(time.RFC3339)
+	token, err := DecodeSToken(encodeTestSToken(`{"token":"abc123","expDate":"` + expDate + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Expired() {
+		t.Error("expected token to be valid")
+	}
+
+	if token.ExpiresWithin(24 * time.Hour) {
+		t.Error("expected token to be valid for at least another day")
+	}
+
+	if !token.ExpiresWithin(72 * time.Hour) {
+		t.Error("expected token to expire within three days")
+	}
+}
+
+func TestSToken_Literal(t *testing.T) {
+	expDate := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	token := &SToken{Token: "abc123", ExpDateStr: expDate.Format(time.RFC3339), OrgName: "Example Inc."}
+
+	if !token.ExpDate().Equal(expDate) {
+		t.Errorf("expected expiry date %s, got %s", expDate, token.ExpDate())
+	}
+	if token.Expired() {
+		t.Error("expected token to be valid")
+	}
+
+	encoded, err := token.Base64String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSToken(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.ExpDate().Equal(expDate) {
+		t.Errorf("expected the decoded expiry date %s, got %s", expDate, decoded.ExpDate())
+	}
+
+	// Changing a decoded token changes its encoding and expiry date.
+	decoded.OrgName = "Other Inc."
+	decoded.ExpDateStr = "2018-03-21T15:13:18-0700"
+	reencoded, err := decoded.Base64String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reencoded == encoded {
+		t.Error("expected the encoding to reflect the changed fields")
+	}
+	if !decoded.Expired() {
+		t.Error("expected the changed expiry date to be used")
+	}
+}
+
+func TestLoadSTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Example_Inc.vpptoken")
+	encoded := encodeTestSToken(`{"token":"abc123","expDate":"2018-03-21T15:13:18-0700","orgName":"Example Inc."}`)
+	if err := ioutil.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := LoadSTokenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.OrgName != "Example Inc." || token.raw != encoded {
+		t.Errorf("unexpected token %#v", token)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not a token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSTokenFile(path); err == nil {
+		t.Error("expected an error loading an invalid token")
+	}
+
+	if _, err := LoadSTokenFile(filepath.Join(dir, "missing.vpptoken")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+}
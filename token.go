@@ -0,0 +1,136 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TokenProvider supplies the sToken sent with each request to the VPP service.
+// It is asked for a token whenever a request is built, so implementations which fetch tokens from elsewhere should
+// cache them.
+type TokenProvider interface {
+	SToken(ctx context.Context) (string, error)
+}
+
+// staticToken is a TokenProvider which always supplies the same sToken.
+type staticToken string
+
+func (t staticToken) SToken(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// tokenSource holds the TokenProvider shared by every service of a client.
+type tokenSource struct {
+	mu       sync.RWMutex
+	provider TokenProvider
+}
+
+// sToken returns the sToken to send with a request, falling back to Config.SToken if no provider was set.
+func (c *vppClient) sToken(ctx context.Context) (string, error) {
+	c.token.mu.RLock()
+	provider := c.token.provider
+	c.token.mu.RUnlock()
+
+	if provider == nil {
+		if c.Config == nil {
+			return "", errors.New("no sToken configured")
+		}
+		return c.Config.SToken, nil
+	}
+	return provider.SToken(ctx)
+}
+
+// RotateSToken replaces the sToken used by every service of the client, eg. after renewing it in Apple Business
+// Manager. Requests already in flight complete with the token they were built with.
+// The token must be valid and not yet expired.
+func (c *vppClient) RotateSToken(sToken string) error {
+	decoded, err := DecodeSToken(sToken)
+	if err != nil {
+		return err
+	}
+	if decoded.Expired() {
+		return fmt.Errorf("sToken for %s expired on %s", decoded.OrgName, decoded.ExpDate())
+	}
+
+	c.SetTokenProvider(staticToken(decoded.raw))
+	return nil
+}
+
+// SetTokenProvider replaces the source of the sToken used by every service of the client.
+func (c *vppClient) SetTokenProvider(provider TokenProvider) {
+	c.token.mu.Lock()
+	defer c.token.mu.Unlock()
+
+	c.token.provider = provider
+}
+
+// TokenProviderFunc adapts a function to a TokenProvider, eg. to fetch the sToken from a secret store.
+type TokenProviderFunc func(ctx context.Context) (string, error)
+
+func (f TokenProviderFunc) SToken(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// FileTokenProvider supplies the sToken stored in a file.
+// The file is read again whenever it is modified, so that a renewed token can be put in place without restarting.
+type FileTokenProvider struct {
+	path string
+
+	mu      sync.Mutex
+	sToken  string
+	modTime time.Time
+}
+
+// NewFileTokenProvider returns a TokenProvider reading the sToken file at the given path.
+func NewFileTokenProvider(path string) *FileTokenProvider {
+	return &FileTokenProvider{path: path}
+}
+
+func (p *FileTokenProvider) SToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", err
+	}
+	if p.sToken != "" && info.ModTime().Equal(p.modTime) {
+		return p.sToken, nil
+	}
+
+	sToken, err := LoadSTokenFile(p.path)
+	if err != nil {
+		return "", err
+	}
+
+	p.sToken = sToken.raw
+	p.modTime = info.ModTime()
+	return p.sToken, nil
+}
+
+// EnvTokenProvider supplies the sToken stored in an environment variable.
+type EnvTokenProvider struct {
+	name string
+}
+
+// NewEnvTokenProvider returns a TokenProvider reading the sToken from the named environment variable.
+func NewEnvTokenProvider(name string) *EnvTokenProvider {
+	return &EnvTokenProvider{name: name}
+}
+
+func (p *EnvTokenProvider) SToken(ctx context.Context) (string, error) {
+	value, ok := os.LookupEnv(p.name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", p.name)
+	}
+
+	sToken, err := DecodeSToken(value)
+	if err != nil {
+		return "", fmt.Errorf("environment variable %s: %w", p.name, err)
+	}
+	return sToken.raw, nil
+}
@@ -0,0 +1,179 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVppClient_RotateSToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := vppSim.MintSToken("Example Inc.", time.Now().AddDate(1, 0, 0))
+	vppSim.RevokeSToken(config.SToken)
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); !IsInvalidSToken(err) {
+		t.Fatalf("expected the revoked token to be rejected, got %v", err)
+	}
+
+	if err := vppClient.RotateSToken(renewed + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Errorf("expected the renewed token to be accepted, got %v", err)
+	}
+}
+
+func TestVppClient_RotateSToken_Invalid(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := vppSim.MintSToken("Example Inc.", time.Now().AddDate(0, 0, -1))
+	for _, sToken := range []string{"", "not a token", expired} {
+		if err := vppClient.RotateSToken(sToken); err == nil {
+			t.Errorf("expected an error rotating to %q", sToken)
+		}
+	}
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Errorf("expected the original token to be kept, got %v", err)
+	}
+}
+
+func TestVppClient_RotateSToken_Concurrent(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := vppClient.GetAssets(false); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := vppClient.RotateSToken(vppSim.MintSToken("Example Inc.", time.Now().AddDate(1, 0, 0))); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	setup()
+	defer teardown()
+
+	dir, err := ioutil.TempDir("", "vpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "Example_Inc.vpptoken")
+	if err := ioutil.WriteFile(path, []byte(config.SToken), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vppClient, err := NewVPPClient(&Config{URL: vppSimURL}, WithTokenProvider(NewFileTokenProvider(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the token file, as an admin would after renewing the token.
+	renewed := vppSim.MintSToken("Example Inc.", time.Now().AddDate(1, 0, 0))
+	vppSim.RevokeSToken(config.SToken)
+	if err := ioutil.WriteFile(path, []byte(renewed), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Errorf("expected the renewed token to be read, got %v", err)
+	}
+}
+
+func TestEnvTokenProvider(t *testing.T) {
+	setup()
+	defer teardown()
+
+	const name = "VPP_TEST_STOKEN"
+	provider := NewEnvTokenProvider(name)
+
+	os.Unsetenv(name)
+	if _, err := provider.SToken(context.Background()); err == nil {
+		t.Error("expected an error if the environment variable is not set")
+	}
+
+	os.Setenv(name, config.SToken)
+	defer os.Unsetenv(name)
+
+	vppClient, err := NewVPPClient(&Config{URL: vppSimURL}, WithTokenProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenProviderFunc(t *testing.T) {
+	setup()
+	defer teardown()
+
+	errUnavailable := errors.New("secret store unavailable")
+	var unavailable bool
+	provider := TokenProviderFunc(func(ctx context.Context) (string, error) {
+		if unavailable {
+			return "", errUnavailable
+		}
+		return config.SToken, nil
+	})
+
+	vppClient, err := NewVPPClient(&Config{URL: vppSimURL}, WithTokenProvider(provider))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vppClient.GetUsers(&BatchRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	unavailable = true
+	if _, err := vppClient.GetUsers(&BatchRequest{}); !errors.Is(err, errUnavailable) {
+		t.Errorf("expected the provider error, got %v", err)
+	}
+}
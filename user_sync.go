@@ -0,0 +1,176 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultUserCheckpointKey is the key under which a UserSyncer saves its checkpoint unless another is given.
+const DefaultUserCheckpointKey = "users"
+
+// UserEventType describes how a user changed since the last sync.
+type UserEventType int
+
+const (
+	UserAdded UserEventType = iota + 1
+	UserChanged
+	UserRetired
+)
+
+func (t UserEventType) String() string {
+	switch t {
+	case UserAdded:
+		return "added"
+	case UserChanged:
+		return "changed"
+	case UserRetired:
+		return "retired"
+	default:
+		return fmt.Sprintf("UserEventType(%d)", int(t))
+	}
+}
+
+// UserEvent reports a user which was added, changed or retired since the last sync.
+type UserEvent struct {
+	Type UserEventType
+	User VPPUser
+}
+
+// UserSyncer keeps a copy of the users of a VPP account current without downloading every user on each run.
+//
+// The first sync walks every active user, reporting each as added, and saves the sinceModifiedToken returned by the
+// VPP service to a CheckpointStore. Later syncs request only the users modified since the checkpoint, including
+// users which were retired.
+//
+// The VPP service does not say whether a modified user is new. The syncer remembers the users it has reported, but
+// after a restart it cannot tell, and reports modified users as changed unless IsKnown is set.
+type UserSyncer struct {
+	// IsKnown optionally reports whether a user is already known to the caller, eg. by looking it up in a database.
+	IsKnown func(clientUserIdStr string) bool
+
+	service UsersService
+	store   CheckpointStore
+	key     string
+
+	syncMu sync.Mutex // held by Sync and Reset
+
+	mu     sync.Mutex
+	known  map[string]bool
+	seeded bool // whether known holds every active user, after a full sync by this syncer
+}
+
+// NewUserSyncer creates a syncer for the users of the given service, saving checkpoints to the store under the key,
+// or DefaultUserCheckpointKey if the key is empty.
+func NewUserSyncer(service UsersService, store CheckpointStore, key string) *UserSyncer {
+	if key == "" {
+		key = DefaultUserCheckpointKey
+	}
+	return &UserSyncer{service: service, store: store, key: key, known: make(map[string]bool)}
+}
+
+// Sync reports every user added, changed or retired since the last sync to the handler.
+// If the handler returns an error the sync stops and the checkpoint is not saved, so that the next sync reports the
+// same users again. The handler must not call Sync or Reset.
+func (s *UserSyncer) Sync(ctx context.Context, handle func(UserEvent) error) error {
+	if handle == nil {
+		return errors.New("no event handler given")
+	}
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	token, err := s.store.LoadCheckpoint(s.key)
+	if err != nil {
+		return err
+	}
+	full := token == ""
+	if full {
+		s.mu.Lock()
+		s.known = make(map[string]bool)
+		s.seeded = false
+		s.mu.Unlock()
+	}
+
+	it := s.service.IterateUsers(ctx, &BatchRequest{SinceModifiedToken: token}, IncludeRetired(!full))
+	for it.Next() {
+		user := it.User()
+		event := UserEvent{Type: s.eventType(user, full), User: user}
+		if full && event.Type == UserRetired {
+			continue
+		}
+
+		if err := handle(event); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if event.Type == UserRetired {
+			delete(s.known, user.ClientUserIdStr)
+		} else {
+			s.known[user.ClientUserIdStr] = true
+		}
+		s.mu.Unlock()
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	next := it.SinceModifiedToken()
+	if next == "" {
+		return errors.New("the VPP service did not return a sinceModifiedToken")
+	}
+	if err := s.store.SaveCheckpoint(s.key, next); err != nil {
+		return err
+	}
+
+	if full {
+		s.mu.Lock()
+		s.seeded = true
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// eventType decides how a modified user is reported. Users which were deleted are reported as retired.
+func (s *UserSyncer) eventType(user VPPUser, full bool) UserEventType {
+	s.mu.Lock()
+	known, seeded := s.known[user.ClientUserIdStr], s.seeded
+	s.mu.Unlock()
+
+	switch {
+	case user.Status == RegStatusRetired || user.Status == RegStatusDeleted:
+		return UserRetired
+	case full:
+		return UserAdded
+	case known:
+		return UserChanged
+	case s.IsKnown != nil:
+		if s.IsKnown(user.ClientUserIdStr) {
+			return UserChanged
+		}
+		return UserAdded
+	case seeded:
+		return UserAdded
+	default:
+		return UserChanged
+	}
+}
+
+// Reset discards the checkpoint, so that the next sync walks every user again.
+func (s *UserSyncer) Reset() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	if err := s.store.SaveCheckpoint(s.key, ""); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.known = make(map[string]bool)
+	s.seeded = false
+	return nil
+}
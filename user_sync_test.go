@@ -0,0 +1,133 @@
+package vpp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func collectUserEvents(t *testing.T, syncer *UserSyncer) map[UserEventType][]string {
+	events := make(map[UserEventType][]string)
+	err := syncer.Sync(context.Background(), func(event UserEvent) error {
+		events[event.Type] = append(events[event.Type], event.User.ClientUserIdStr)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestUserSyncer_Sync(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryCheckpointStore()
+	syncer := NewUserSyncer(vppClient, store, "")
+
+	// The first sync walks every user, across several batches.
+	events := collectUserEvents(t, syncer)
+	if len(events[UserAdded]) != 101 || len(events) != 1 {
+		t.Fatalf("expected 101 added users, got %v", events)
+	}
+	if token, _ := store.LoadCheckpoint(DefaultUserCheckpointKey); token == "" {
+		t.Error("expected the checkpoint to be saved")
+	}
+
+	// Later syncs only report what was modified.
+	if _, err := vppClient.RegisterUser(&VPPUser{ClientUserIdStr: "new-user", Email: "new@localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vppClient.EditUser(&VPPUser{ClientUserIdStr: "user-1", Email: "renamed@localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vppClient.RetireUser(&VPPUser{ClientUserIdStr: "user-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events = collectUserEvents(t, syncer)
+	if len(events[UserAdded]) != 1 || events[UserAdded][0] != "new-user" ||
+		len(events[UserChanged]) != 1 || events[UserChanged][0] != "user-1" ||
+		len(events[UserRetired]) != 1 || events[UserRetired][0] != "user-2" {
+		t.Errorf("unexpected events %v", events)
+	}
+	if syncer.known["user-2"] || len(syncer.known) != 101 {
+		t.Errorf("expected the retired user to be forgotten, got %d known users", len(syncer.known))
+	}
+
+	if events = collectUserEvents(t, syncer); len(events) != 0 {
+		t.Errorf("expected no events without modifications, got %v", events)
+	}
+}
+
+func TestUserSyncer_HandlerError(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryCheckpointStore()
+	syncer := NewUserSyncer(vppClient, store, "example")
+
+	errStop := errors.New("stop")
+	err = syncer.Sync(context.Background(), func(event UserEvent) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if token, _ := store.LoadCheckpoint("example"); token != "" {
+		t.Error("expected the checkpoint not to be saved after an error")
+	}
+
+	if events := collectUserEvents(t, syncer); len(events[UserAdded]) != 101 {
+		t.Errorf("expected the failed sync to be repeated, got %v", events)
+	}
+}
+
+func TestUserSyncer_Restart(t *testing.T) {
+	setup()
+	defer teardown()
+
+	dir, err := ioutil.TempDir("", "vpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json"))
+	collectUserEvents(t, NewUserSyncer(vppClient, store, ""))
+
+	if _, err := vppClient.RegisterUser(&VPPUser{ClientUserIdStr: "new-user"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vppClient.EditUser(&VPPUser{ClientUserIdStr: "user-1", Email: "renamed@localhost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the users seen before the restart, the syncer relies on IsKnown to tell new users apart.
+	restarted := NewUserSyncer(vppClient, NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")), "")
+	restarted.IsKnown = func(clientUserIdStr string) bool {
+		return clientUserIdStr != "new-user"
+	}
+	events := collectUserEvents(t, restarted)
+	if len(events[UserAdded]) != 1 || len(events[UserChanged]) != 1 {
+		t.Errorf("unexpected events %v", events)
+	}
+}
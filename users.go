@@ -1,6 +1,7 @@
 package vpp
 
 import (
+	"context"
 	"github.com/satori/go.uuid"
 )
 
@@ -8,6 +9,7 @@ const (
 	RegStatusRegistered string = "Registered"
 	RegStatusAssociated        = "Associated"
 	RegStatusRetired           = "Retired"
+	RegStatusDeleted           = "Deleted"
 )
 
 // VPPUser describes the attributes of a VPP user.
@@ -37,15 +39,20 @@ func NewUser(email string, id string) *VPPUser {
 // UsersService interface describes the methods available as part of the VPP user management API.
 type UsersService interface {
 	RegisterUser(user *VPPUser) (*VPPUser, error)
+	RegisterUserWithContext(ctx context.Context, user *VPPUser) (*VPPUser, error)
 	GetUser(*VPPUser) error
+	GetUserWithContext(ctx context.Context, user *VPPUser) error
 	GetUsers(batch *BatchRequest, opts ...GetUsersOption) ([]VPPUser, error)
+	GetUsersWithContext(ctx context.Context, batch *BatchRequest, opts ...GetUsersOption) ([]VPPUser, error)
 	RetireUser(user *VPPUser) error
+	RetireUserWithContext(ctx context.Context, user *VPPUser) error
 	EditUser(user *VPPUser) error
+	EditUserWithContext(ctx context.Context, user *VPPUser) error
+	IterateUsers(ctx context.Context, batch *BatchRequest, opts ...GetUsersOption) *UsersIterator
 }
 
 type usersService struct {
 	client *vppClient
-	sToken string
 }
 
 type registerVPPUserSrvRequest struct {
@@ -61,13 +68,28 @@ type registerVPPUserSrvResponse struct {
 
 // RegisterUser registers a new VPP user
 func (s *usersService) RegisterUser(user *VPPUser) (*VPPUser, error) {
+	return s.RegisterUserWithContext(context.Background(), user)
+}
+
+// RegisterUserWithContext is like RegisterUser but carries a context for cancellation and deadlines.
+func (s *usersService) RegisterUserWithContext(ctx context.Context, user *VPPUser) (*VPPUser, error) {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var response *registerVPPUserSrvResponse
 	var request *registerVPPUserSrvRequest = &registerVPPUserSrvRequest{
 		VPPUser: user,
-		SToken:  s.sToken,
+		SToken:  sToken,
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.RegisterUserSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.RegisterUserSrvURL, request)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +100,7 @@ func (s *usersService) RegisterUser(user *VPPUser) (*VPPUser, error) {
 	}
 
 	if response.Status == StatusErr {
-		return nil, response.VPPError
+		return nil, s.client.vppError(response.VPPError)
 	}
 
 	return response.User, nil
@@ -99,13 +121,28 @@ type getVPPUserSrvResponse struct {
 
 // GetUser gets any number of users associated with the given ClientIdStr
 func (s *usersService) GetUser(user *VPPUser) error {
+	return s.GetUserWithContext(context.Background(), user)
+}
+
+// GetUserWithContext is like GetUser but carries a context for cancellation and deadlines.
+func (s *usersService) GetUserWithContext(ctx context.Context, user *VPPUser) error {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return err
+	}
+
 	var response *getVPPUserSrvResponse
 	var request *getVPPUserSrvRequest = &getVPPUserSrvRequest{
 		ClientUserIdStr: user.ClientUserIdStr,
-		SToken:          s.sToken,
+		SToken:          sToken,
 	}
 
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.GetUserSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.GetUserSrvURL, request)
 	if err != nil {
 		return err
 	}
@@ -116,7 +153,7 @@ func (s *usersService) GetUser(user *VPPUser) error {
 	}
 
 	if response.Status == StatusErr {
-		return response.VPPError
+		return s.client.vppError(response.VPPError)
 	}
 
 	user.UserID = response.User.UserID
@@ -165,19 +202,34 @@ type getVPPUsersSrvResponse struct {
 
 // GetUsers obtains a list of all known users from the VPP server
 func (s *usersService) GetUsers(batch *BatchRequest, opts ...GetUsersOption) ([]VPPUser, error) {
+	return s.GetUsersWithContext(context.Background(), batch, opts...)
+}
+
+// GetUsersWithContext is like GetUsers but carries a context for cancellation and deadlines.
+func (s *usersService) GetUsersWithContext(ctx context.Context, batch *BatchRequest, opts ...GetUsersOption) ([]VPPUser, error) {
 	requestOpts := &getUsersRequestOpts{}
 	for _, option := range opts {
 		if err := option(requestOpts); err != nil {
 			return nil, err
 		}
 	}
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var request *getVPPUsersSrvRequest = &getVPPUsersSrvRequest{
 		getUsersRequestOpts: requestOpts,
 		BatchRequest:        batch,
-		SToken:              s.sToken,
+		SToken:              sToken,
 	}
 	var response getVPPUsersSrvResponse
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.GetUsersSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.GetUsersSrvURL, request)
 	if err != nil {
 		return nil, err
 	}
@@ -186,15 +238,74 @@ func (s *usersService) GetUsers(batch *BatchRequest, opts ...GetUsersOption) ([]
 		return nil, err
 	}
 	if response.Status == StatusErr {
-		return nil, response.VPPError
+		return nil, s.client.vppError(response.VPPError)
 	}
 
-	batch.BatchToken = response.BatchToken
-	batch.SinceModifiedToken = response.SinceModifiedToken
+	if batch != nil {
+		batch.update(response.BatchToken, response.SinceModifiedToken, response.TotalCount, 0)
+	}
 
 	return response.Users, nil
 }
 
+// UsersIterator walks every user returned by GetUsers, requesting each batch as the previous one is exhausted.
+//
+//	it := client.IterateUsers(ctx, nil)
+//	for it.Next() {
+//		user := it.User()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//	nextSync := it.SinceModifiedToken()
+type UsersIterator struct {
+	batchIterator
+	service UsersService
+	opts    []GetUsersOption
+
+	users   []VPPUser
+	current VPPUser
+}
+
+// NewUsersIterator creates an iterator over the users of the given service.
+// Pass a batch containing a SinceModifiedToken to iterate only over users that changed since that token was issued.
+// The batch given is not modified.
+func NewUsersIterator(ctx context.Context, service UsersService, batch *BatchRequest, opts ...GetUsersOption) *UsersIterator {
+	return &UsersIterator{
+		batchIterator: newBatchIterator(ctx, batch),
+		service:       service,
+		opts:          opts,
+	}
+}
+
+// IterateUsers returns an iterator over every user, following batch tokens automatically.
+func (s *usersService) IterateUsers(ctx context.Context, batch *BatchRequest, opts ...GetUsersOption) *UsersIterator {
+	return NewUsersIterator(ctx, s, batch, opts...)
+}
+
+// Next advances the iterator to the next user, fetching the next batch if required.
+// It returns false when there are no more users, or when an error occurred.
+func (it *UsersIterator) Next() bool {
+	if !it.next(it.fetch) {
+		return false
+	}
+
+	it.current, it.users = it.users[0], it.users[1:]
+	return true
+}
+
+func (it *UsersIterator) fetch(ctx context.Context, batch *BatchRequest) (int, error) {
+	users, err := it.service.GetUsersWithContext(ctx, batch, it.opts...)
+	it.users = users
+	return len(users), err
+}
+
+// User returns the user that the iterator is currently positioned at.
+func (it *UsersIterator) User() VPPUser {
+	return it.current
+}
+
 type retireVPPUserSrvRequest struct {
 	UserId          int    `json:"userId,omitempty"`
 	ClientUserIDStr string `json:"clientUserIdStr,omitempty"`
@@ -209,13 +320,28 @@ type retireVPPUserSrvResponse struct {
 
 // RetireUser disassociates our user id with an itunes user id. All revocable licenses are then freed.
 func (s *usersService) RetireUser(user *VPPUser) error {
+	return s.RetireUserWithContext(context.Background(), user)
+}
+
+// RetireUserWithContext is like RetireUser but carries a context for cancellation and deadlines.
+func (s *usersService) RetireUserWithContext(ctx context.Context, user *VPPUser) error {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return err
+	}
+
 	var response *retireVPPUserSrvResponse
 	var request *retireVPPUserSrvRequest = &retireVPPUserSrvRequest{
 		UserId:          user.UserID,
 		ClientUserIDStr: user.ClientUserIdStr,
-		SToken:          s.sToken,
+		SToken:          sToken,
+	}
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return err
 	}
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.RetireUserSrvURL, request)
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.RetireUserSrvURL, request)
 	if err != nil {
 		return err
 	}
@@ -226,7 +352,7 @@ func (s *usersService) RetireUser(user *VPPUser) error {
 	}
 
 	if response.Status == StatusErr {
-		return response.VPPError
+		return s.client.vppError(response.VPPError)
 	}
 
 	user.Status = response.User.Status
@@ -248,14 +374,29 @@ type editVPPUserSrvResponse struct {
 
 // EditUser edits the e-mail address associated with a user
 func (s *usersService) EditUser(user *VPPUser) error {
+	return s.EditUserWithContext(context.Background(), user)
+}
+
+// EditUserWithContext is like EditUser but carries a context for cancellation and deadlines.
+func (s *usersService) EditUserWithContext(ctx context.Context, user *VPPUser) error {
+	sToken, err := s.client.sToken(ctx)
+	if err != nil {
+		return err
+	}
+
 	var response *editVPPUserSrvResponse
 	var request *editVPPUserSrvRequest = &editVPPUserSrvRequest{
 		UserId:          user.UserID,
 		ClientUserIDStr: user.ClientUserIdStr,
 		Email:           user.Email,
-		SToken:          s.sToken,
+		SToken:          sToken,
 	}
-	req, err := s.client.NewRequest("POST", s.client.Config.serviceConfig.EditUserSrvURL, request)
+	serviceConfig, err := s.client.loadServiceConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.client.NewRequestWithContext(ctx, "POST", serviceConfig.EditUserSrvURL, request)
 	if err != nil {
 		return err
 	}
@@ -266,7 +407,7 @@ func (s *usersService) EditUser(user *VPPUser) error {
 	}
 
 	if response.Status == StatusErr {
-		return response.VPPError
+		return s.client.vppError(response.VPPError)
 	}
 
 	user = response.User
@@ -1,8 +1,16 @@
 package vpp
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/mosen/vpp/vpptest"
 )
 
 func TestUsersService_RegisterUser(t *testing.T) {
@@ -105,3 +113,114 @@ func TestUsersService_EditUser(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// batchedUsersHandler serves the given pages of users, linking them together with batch tokens.
+func batchedUsersHandler(t *testing.T, pages [][]VPPUser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			BatchToken         string `json:"batchToken"`
+			SinceModifiedToken string `json:"sinceModifiedToken"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+
+		page := 0
+		if request.BatchToken != "" {
+			page, _ = strconv.Atoi(strings.TrimPrefix(request.BatchToken, "batch-"))
+		}
+
+		response := getVPPUsersSrvResponse{Users: pages[page]}
+		if page < len(pages)-1 {
+			response.BatchToken = fmt.Sprintf("batch-%d", page+1)
+		} else {
+			response.SinceModifiedToken = "modified-since"
+		}
+		json.NewEncoder(w).Encode(&response)
+	}
+}
+
+func TestUsersIterator(t *testing.T) {
+	pages := [][]VPPUser{
+		{{ClientUserIdStr: "1"}, {ClientUserIdStr: "2"}},
+		{},
+		{{ClientUserIdStr: "3"}},
+	}
+	srv := httptest.NewServer(batchedUsersHandler(t, pages))
+	defer srv.Close()
+
+	it := newTestClient(srv).IterateUsers(context.Background(), nil)
+	var ids []string
+	for it.Next() {
+		ids = append(ids, it.User().ClientUserIdStr)
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("expected users 1,2,3 but got %v", ids)
+	}
+
+	if token := it.SinceModifiedToken(); token != "modified-since" {
+		t.Errorf("expected since modified token, got %q", token)
+	}
+}
+
+func TestUsersIterator_Canceled(t *testing.T) {
+	pages := [][]VPPUser{
+		{{ClientUserIdStr: "1"}},
+		{{ClientUserIdStr: "2"}},
+	}
+	srv := httptest.NewServer(batchedUsersHandler(t, pages))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := newTestClient(srv).IterateUsers(ctx, nil)
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+
+	cancel()
+	if it.Next() {
+		t.Error("expected iteration to stop after cancellation")
+	}
+
+	if it.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", it.Err())
+	}
+
+	if it.SinceModifiedToken() != "" {
+		t.Error("expected no since modified token from an incomplete iteration")
+	}
+}
+
+func TestUsersIterator_FailsMidway(t *testing.T) {
+	setup()
+	defer teardown()
+
+	vppSim.BatchSize = 40
+	vppSim.Play(&vpptest.Scenario{Responses: map[string][]vpptest.ResponseFault{
+		"getVPPUsersSrv": {{Count: 1, After: 1, Response: vpptest.ResponseVPPError, ErrorNumber: ErrorNumberInternalError}},
+	}})
+
+	vppClient, err := NewVPPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := vppClient.IterateUsers(context.Background(), nil)
+	var count int
+	for it.Next() {
+		count++
+	}
+
+	if count != 40 {
+		t.Errorf("expected the first batch of 40 users before the failure, got %d", count)
+	}
+
+	if !IsRetryable(it.Err()) {
+		t.Errorf("expected a retryable internal error, got %v", it.Err())
+	}
+}
@@ -2,14 +2,17 @@ package vpp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -26,25 +29,48 @@ const (
 	StatusOk
 )
 
+// DefaultMaxRetries is the number of times a request is replayed after a Retry-After response when
+// Config.MaxRetries is not set.
+const DefaultMaxRetries = 3
+
 type Config struct {
-	URL           *url.URL
-	SToken        string
-	debug         bool
-	serviceConfig *ServiceConfig
+	URL    *url.URL
+	SToken string
+
+	// MaxRetries is the number of times a request is replayed when the VPP service responds with a Retry-After
+	// header. Zero uses DefaultMaxRetries, a negative value disables retries.
+	MaxRetries int
+
+	// MaxConcurrentLicenseRequests bounds the number of manageVPPLicensesByAdamIdSrv requests that ManageLicenses
+	// will have in flight when it splits a large operation into chunks. Zero or one submits chunks sequentially.
+	MaxConcurrentLicenseRequests int
 }
 
-type VPPError struct {
-	ErrorMessage string `json:"errorMessage"`
-	ErrorNumber  int    `json:"errorNumber"`
+func (c *Config) maxConcurrentLicenseRequests() int {
+	if c == nil || c.MaxConcurrentLicenseRequests < 1 {
+		return 1
+	}
+	return c.MaxConcurrentLicenseRequests
 }
 
-func (e VPPError) Error() string {
-	return fmt.Sprintf("(%d) %s", e.ErrorNumber, e.ErrorMessage)
+func (c *Config) maxRetries() int {
+	switch {
+	case c == nil || c.MaxRetries == 0:
+		return DefaultMaxRetries
+	case c.MaxRetries < 0:
+		return 0
+	default:
+		return c.MaxRetries
+	}
 }
 
 type VPPClient interface {
 	NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+	NewRequestWithContext(ctx context.Context, method, urlStr string, body interface{}) (*http.Request, error)
 	Do(req *http.Request, into interface{}) error
+	DoWithContext(ctx context.Context, req *http.Request, into interface{}) error
+	RotateSToken(sToken string) error
+	SetTokenProvider(provider TokenProvider)
 
 	AssetsService
 	ConfigService
@@ -63,6 +89,15 @@ type vppClient struct {
 
 	Config *Config
 
+	serviceConfig serviceConfigCache
+	token         tokenSource
+
+	logger    Logger
+	logBodies bool
+	limiter   *rateLimiter
+
+	instrumentation []Instrumentation
+
 	assetsService
 	configService
 	licensesService
@@ -70,7 +105,13 @@ type vppClient struct {
 	usersService
 }
 
+// NewRequest creates an API request. The body, if given, is encoded as JSON.
 func (c *vppClient) NewRequest(method, urlStr string, body interface{}) (*http.Request, error) {
+	return c.NewRequestWithContext(context.Background(), method, urlStr, body)
+}
+
+// NewRequestWithContext is like NewRequest but the returned request carries the given context.
+func (c *vppClient) NewRequestWithContext(ctx context.Context, method, urlStr string, body interface{}) (*http.Request, error) {
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
@@ -84,12 +125,16 @@ func (c *vppClient) NewRequest(method, urlStr string, body interface{}) (*http.R
 		}
 	}
 
-	req, err := http.NewRequest(method, u.String(), buf)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("User-Agent", userAgent)
+	if c.UserAgent != "" {
+		req.Header.Add("User-Agent", c.UserAgent)
+	} else {
+		req.Header.Add("User-Agent", userAgent)
+	}
 	req.Header.Add("Content-Type", mediaType)
 	req.Header.Add("Accept", mediaType)
 
@@ -97,91 +142,234 @@ func (c *vppClient) NewRequest(method, urlStr string, body interface{}) (*http.R
 }
 
 // Do sends an API request and returns the API response.
+// The request first waits for any rate limit set with WithRateLimit or WithEndpointRateLimit.
+//
+// The VPP service may issue either a 3xx (redirect) or 503 (unavailable) with a Retry-After header
+// if the service is overloaded or this client is causing too much load. In that case the request is replayed after
+// the requested delay, up to Config.MaxRetries times, and every other request of the client, to any endpoint, waits
+// until the delay has passed, whether or not rate limits were set. A *ServiceBusyError is returned once the budget
+// is exhausted, or when the delay would exceed the deadline of the request context. If the context is done while
+// waiting, its error is returned instead.
 func (c *vppClient) Do(req *http.Request, into interface{}) error {
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return err
-	}
+	metrics, err := c.send(req, into)
+	c.observe(metrics)
 
-	// The VPP service may issue either a 3xx (redirect) or 503 (unavailable) with a Retry-After header
-	// if the service is overloaded or this client is causing too much load.
-	// The Retry-After header may be in seconds or as a date.
+	return err
+}
 
-	if resp.StatusCode == http.StatusPermanentRedirect || resp.StatusCode == http.StatusTemporaryRedirect {
-		retryAfter := resp.Header.Get("Retry-After")
-		fmt.Printf("Retry-After %s", retryAfter)
-		matched, err := regexp.MatchString("^[0-9]+$", retryAfter)
-		if err != nil {
+// send is like Do, but returns the metrics of the request instead of reporting them to Instrumentation, so that the
+// caller can report them once it has finished with the response.
+func (c *vppClient) send(req *http.Request, into interface{}) (RequestMetrics, error) {
+	metrics := RequestMetrics{Endpoint: endpointName(req.URL), Method: req.Method}
+	start := time.Now()
+	err := c.do(req, into, &metrics)
+	metrics.Duration = time.Since(start)
+	metrics.Err = err
+
+	return metrics, err
+}
+
+// do sends the request, recording its outcome in metrics.
+func (c *vppClient) do(req *http.Request, into interface{}, metrics *RequestMetrics) error {
+	endpoint := metrics.Endpoint
+	c.logRequestBody(req, endpoint)
+
+	for attempt := 0; ; attempt++ {
+		metrics.Retries = attempt
+		if err := c.limiter.wait(req.Context(), endpoint); err != nil {
 			return err
 		}
 
-		if matched {
-
-		} else {
+		start := time.Now()
+		resp, err := c.client.Do(req)
+		if err != nil {
+			c.log().Error("vpp request failed", "method", req.Method, "endpoint", endpoint,
+				"latency", time.Since(start), "retries", attempt, "error", err)
+			return err
+		}
 
+		metrics.StatusCode = resp.StatusCode
+
+		if isRetryAfterStatus(resp.StatusCode) {
+			wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if ok {
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				c.limiter.throttled(endpoint, wait)
+
+				busy := &ServiceBusyError{StatusCode: resp.StatusCode, RetryAfter: wait, Retries: attempt}
+				if attempt >= c.Config.maxRetries() {
+					c.log().Error("vpp service busy, giving up", "method", req.Method, "endpoint", endpoint,
+						"status", resp.StatusCode, "latency", time.Since(start), "retries", attempt, "retry_after", wait)
+					return busy
+				}
+				c.log().Warn("vpp service busy, retrying", "method", req.Method, "endpoint", endpoint,
+					"status", resp.StatusCode, "latency", time.Since(start), "retries", attempt, "retry_after", wait)
+				if err := sleepContext(req.Context(), wait); err != nil {
+					c.log().Error("vpp service busy, giving up", "method", req.Method, "endpoint", endpoint,
+						"status", resp.StatusCode, "retries", attempt, "retry_after", wait, "error", err)
+					if req.Context().Err() != nil {
+						return err
+					}
+					busy.Err = err
+					return busy
+				}
+				if err := rewindBody(req); err != nil {
+					return fmt.Errorf("replaying request: %w", err)
+				}
+				continue
+			}
 		}
-	}
 
-	if resp.StatusCode == http.StatusServiceUnavailable {
-		retryAfter := resp.Header.Get("Retry-After")
-		fmt.Printf("Retry-After %s", retryAfter)
-		matched, err := regexp.MatchString("^[0-9]+$", retryAfter)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		latency := time.Since(start)
 		if err != nil {
+			c.log().Error("vpp request failed", "method", req.Method, "endpoint", endpoint,
+				"status", resp.StatusCode, "latency", latency, "retries", attempt, "error", err)
 			return err
 		}
+		c.logResponseBody(req, endpoint, body)
 
-		if matched {
-			fmt.Println("Retry-After was in seconds")
-		} else {
-			//retryTime := time.Parse()
+		if resp.StatusCode != http.StatusOK {
+			c.log().Error("vpp request failed", "method", req.Method, "endpoint", endpoint,
+				"status", resp.StatusCode, "latency", latency, "retries", attempt)
+			return &HTTPError{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
 		}
 
+		c.log().Debug("vpp request", "method", req.Method, "endpoint", endpoint,
+			"status", resp.StatusCode, "latency", latency, "retries", attempt)
+		if len(c.instrumentation) > 0 {
+			metrics.ErrorNumber = peekErrorNumber(body)
+		}
+		return json.Unmarshal(body, into)
 	}
+}
+
+// DoWithContext is like Do but sends the request with the given context, replacing the context of the request.
+func (c *vppClient) DoWithContext(ctx context.Context, req *http.Request, into interface{}) error {
+	return c.Do(req.WithContext(ctx), into)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("VPP API Error: %v", string(body))
+// isRetryAfterStatus reports whether the status code is one that the VPP service pairs with a Retry-After header.
+func isRetryAfterStatus(code int) bool {
+	return code == http.StatusServiceUnavailable ||
+		code == http.StatusTemporaryRedirect ||
+		code == http.StatusPermanentRedirect ||
+		code == http.StatusFound ||
+		code == http.StatusSeeOther
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which may be given either in delta-seconds or as an
+// HTTP-date. Dates in the past yield a zero delay.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
 	}
 
-	defer func() {
-		if rerr := resp.Body.Close(); err == nil {
-			err = rerr
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
 		}
-	}()
+		return time.Duration(secs) * time.Second, true
+	}
+
+	retryTime, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
 
-	return decodeJSON(c.Config.debug, resp.Body, into)
+	wait := retryTime.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
 }
 
-func decodeJSON(debug bool, body io.Reader, into interface{}) error {
-	var dec *json.Decoder
-	if debug {
-		dec = json.NewDecoder(io.TeeReader(body, os.Stdout))
-	} else {
-		dec = json.NewDecoder(body)
+// sleepContext waits for the given duration, returning early with an error if the context is done or if its
+// deadline would pass before the duration has elapsed.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+		return context.DeadlineExceeded
 	}
 
-	return dec.Decode(into)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
-func NewVPPClient(config *Config) (VPPClient, error) {
+// rewindBody resets the request body so that the request can be sent again.
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody == nil {
+		return errors.New("request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// NewVPPClient creates a client for the VPP service.
+// The service configuration is not fetched until it is first needed, unless it is given with WithServiceConfig.
+// Options may be given to customise the HTTP client used.
+func NewVPPClient(config *Config, options ...ClientOption) (VPPClient, error) {
+	opts := &clientOpts{userAgent: userAgent, serviceConfigTTL: DefaultServiceConfigTTL}
+	for _, option := range options {
+		if err := option(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// The config is copied so that options such as WithBaseURL do not carry over to other clients sharing it.
+	if config == nil {
+		config = &Config{}
+	}
+	copied := *config
+	config = &copied
+
+	if opts.baseURL != nil {
+		config.URL = opts.baseURL
+	}
+
 	if config.URL == nil {
 		config.URL, _ = url.Parse(defaultBaseURL)
 	}
 
-	c := &vppClient{client: http.DefaultClient, BaseURL: config.URL, Config: config}
+	c := &vppClient{client: opts.newHTTPClient(), BaseURL: config.URL, UserAgent: opts.userAgent, Config: config}
+	c.serviceConfig.ttl = opts.serviceConfigTTL
+	if opts.serviceConfig != nil {
+		c.serviceConfig.config = opts.serviceConfig
+		c.serviceConfig.fetchedAt = time.Now()
+	}
 
-	c.configService = configService{client: c, sToken: config.SToken}
+	c.logger = opts.logger
+	c.logBodies = opts.logBodies
+	c.limiter = newRateLimiter(opts.rateLimit, opts.endpointRateLimits)
+	c.instrumentation = opts.instrumentation
 
-	serviceConfig, err := c.ServiceConfig()
-	if err != nil {
-		return nil, err
+	c.token.provider = opts.tokenProvider
+	if c.token.provider == nil {
+		c.token.provider = staticToken(config.SToken)
 	}
-	c.Config.serviceConfig = serviceConfig
 
-	c.assetsService = assetsService{client: c, sToken: config.SToken}
-	c.licensesService = licensesService{client: c, sToken: config.SToken}
-	c.metadataService = metadataService{client: c, sToken: config.SToken}
-	c.usersService = usersService{client: c, sToken: config.SToken}
+	c.configService = configService{client: c}
+	c.assetsService = assetsService{client: c}
+	c.licensesService = licensesService{client: c}
+	c.metadataService = metadataService{client: c, lookupURL: opts.lookupURL, country: opts.lookupCountry}
+	c.usersService = usersService{client: c}
 
 	return c, nil
 }
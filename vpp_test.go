@@ -1,50 +1,48 @@
 package vpp
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
-	"os/exec"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mosen/vpp/vpptest"
 )
 
 var (
 	config             *Config
 	expDateStr         string
 	vppSimURL          *url.URL
+	vppSim             *vpptest.Server
 	clientIdStrFixture string
 )
 
-// Get the current VPPsim token (assuming vppsim is running from this directory)
-func VPPSimToken() string {
-	tokenCmd := exec.Command("./vppsim", "stoken", "-port", "9001")
-	var tokenOut bytes.Buffer
-	tokenCmd.Stdout = &tokenOut
-	err := tokenCmd.Run()
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("Got token from VPPsim: %s", tokenOut.String())
-	return tokenOut.String()
-}
-
+// setup starts a fake VPP service containing a registered user and some licenses.
 func setup() {
-	// get SToken from running VPPsim if possible
-	tokenStr := VPPSimToken()
-
-	vppSimURL, _ = url.Parse("http://localhost:9001")
+	vppSim = vpptest.NewServer()
+	vppSimURL, _ = url.Parse(vppSim.URL)
 	clientIdStrFixture = "eefe2e28-2f64-46ce-9bf5-726f5eda50a0"
 
+	vppSim.AddUser(clientIdStrFixture, "fixture@localhost")
+	for i := 0; i < vpptest.DefaultBatchSize; i++ {
+		vppSim.AddUser(fmt.Sprintf("user-%d", i), fmt.Sprintf("user-%d@localhost", i))
+	}
+	vppSim.AddLicenses(vpptest.Asset{AdamIDStr: "408709785", DeviceAssignable: true}, 10)
+
 	config = &Config{
 		URL:    vppSimURL,
-		SToken: tokenStr,
-		debug:  true,
+		SToken: vppSim.SToken(),
 	}
 }
 
 func teardown() {
-
+	vppSim.Close()
 }
 
 func TestNewVPPClient(t *testing.T) {
@@ -62,25 +60,36 @@ func TestVppClient_Do_RetryAfterSecs(t *testing.T) {
 	setup()
 	defer teardown()
 
+	scenario, err := vpptest.LoadScenario("test/vppsim_retryaftersecs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vppSim.Play(scenario)
+
 	vppClient, err := NewVPPClient(config)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// The service asks for 20 seconds, which is beyond the deadline, so the client should give up straight away.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	requestBody := getVPPUserSrvRequest{SToken: config.SToken}
-	req, err := vppClient.NewRequest("POST", fmt.Sprintf("%s/getVPPUsersSrv", vppSimURL), &requestBody)
+	req, err := vppClient.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/getVPPUsersSrv", vppSimURL), &requestBody)
 	if err != nil {
 		t.Error(err)
 	}
 
 	var response getVPPUsersSrvResponse
 	err = vppClient.Do(req, &response)
-	if err != nil {
-		t.Error(err)
+	var busy *ServiceBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("expected *ServiceBusyError, got %#v", err)
 	}
 
-	if response.VPPError != nil {
-		t.Errorf("VPP error: %s", response.VPPError.Error())
+	if busy.RetryAfter != 20*time.Second || busy.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a 503 asking for 20 seconds, got %#v", busy)
 	}
 }
 
@@ -89,6 +98,14 @@ func TestVppClient_Do_RetryAfterHTTPDate(t *testing.T) {
 	setup()
 	defer teardown()
 
+	scenario, err := vpptest.LoadScenario("test/vppsim_retryafterdate.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vppSim.Play(scenario)
+
+	// The service is unavailable for 10 requests, with a Retry-After date in the past.
+	config.MaxRetries = 10
 	vppClient, err := NewVPPClient(config)
 	if err != nil {
 		t.Fatal(err)
@@ -106,7 +123,238 @@ func TestVppClient_Do_RetryAfterHTTPDate(t *testing.T) {
 		t.Error(err)
 	}
 
-	if response.VPPError.ErrorMessage != "" {
+	if response.VPPError != nil {
 		t.Errorf("VPP error: %s", response.VPPError.Error())
 	}
+
+	if requests := vppSim.Requests("getVPPUsersSrv"); requests != 11 {
+		t.Errorf("expected 11 requests, got %d", requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"20", 20 * time.Second, true},
+		{" 0 ", 0, true},
+		{"Sun, 01 Jan 2017 00:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 31 Dec 1999 23:59:59 GMT", 0, true},
+		{"Sunday, 01-Jan-17 00:01:00 GMT", time.Minute, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		wait, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.ok || wait != tt.wait {
+			t.Errorf("parseRetryAfter(%q) = (%s, %v), expected (%s, %v)", tt.value, wait, ok, tt.wait, tt.ok)
+		}
+	}
+}
+
+// retryAfterServer responds to the first busyCount requests with a 503 and the given Retry-After value.
+func retryAfterServer(t *testing.T, busyCount int, retryAfter string) (*httptest.Server, *int) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), "sToken") {
+			t.Errorf("request %d was sent without a body", requests)
+		}
+		if requests <= busyCount {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":0,"users":[{"clientUserIdStr":"abc"}]}`))
+	}))
+
+	return srv, &requests
+}
+
+func TestVppClient_Do_RetriesAfterServiceUnavailable(t *testing.T) {
+	srv, requests := retryAfterServer(t, 2, "0")
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{}}
+	req, err := c.NewRequest("POST", srv.URL, &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response getVPPUsersSrvResponse
+	if err := c.Do(req, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if *requests != 3 {
+		t.Errorf("expected 3 requests, got %d", *requests)
+	}
+
+	if len(response.Users) != 1 {
+		t.Errorf("expected the response of the final attempt to be decoded, got %#v", response)
+	}
+}
+
+func TestVppClient_Do_RetryBudgetExhausted(t *testing.T) {
+	srv, requests := retryAfterServer(t, 10, "Fri, 31 Dec 1999 23:59:59 GMT")
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{MaxRetries: 2}}
+	req, err := c.NewRequest("POST", srv.URL, &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response getVPPUsersSrvResponse
+	err = c.Do(req, &response)
+	busy, ok := err.(*ServiceBusyError)
+	if !ok {
+		t.Fatalf("expected *ServiceBusyError, got %#v", err)
+	}
+
+	if busy.Retries != 2 || *requests != 3 {
+		t.Errorf("expected 2 retries over 3 requests, got %d retries over %d requests", busy.Retries, *requests)
+	}
+}
+
+func TestVppClient_Do_RetryAfterExceedsDeadline(t *testing.T) {
+	srv, requests := retryAfterServer(t, 10, "20")
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{}}
+	req, err := c.NewRequest("POST", srv.URL, &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err = c.Do(req.WithContext(ctx), &getVPPUsersSrvResponse{})
+	if _, ok := err.(*ServiceBusyError); !ok {
+		t.Fatalf("expected *ServiceBusyError, got %#v", err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be the cause, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond || *requests != 1 {
+		t.Errorf("expected to give up immediately, waited %s over %d requests", elapsed, *requests)
+	}
+}
+
+func TestVppClient_Do_CanceledWhileRetrying(t *testing.T) {
+	srv, _ := retryAfterServer(t, 10, "20")
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{}}
+	req, err := c.NewRequest("POST", srv.URL, &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err = c.Do(req.WithContext(ctx), &getVPPUsersSrvResponse{})
+	if !errors.Is(err, context.Canceled) || IsRetryable(err) {
+		t.Errorf("expected the cancellation to be returned, got %#v", err)
+	}
+}
+
+func TestVppClient_Do_BodyNotReplayable(t *testing.T) {
+	srv, _ := retryAfterServer(t, 1, "0")
+	defer srv.Close()
+
+	c := &vppClient{client: srv.Client(), Config: &Config{}}
+	req, err := c.NewRequest("POST", srv.URL, &getVPPUsersSrvRequest{SToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.GetBody = nil
+
+	err = c.Do(req, &getVPPUsersSrvResponse{})
+	var busy *ServiceBusyError
+	if err == nil || errors.As(err, &busy) || !strings.Contains(err.Error(), "replaying request") {
+		t.Errorf("expected an error replaying the request, got %#v", err)
+	}
+}
+
+// newTestClient returns a client whose service URLs all point at the given test server.
+func newTestClient(srv *httptest.Server) *vppClient {
+	u, _ := url.Parse(srv.URL)
+	config := &Config{URL: u, SToken: "token"}
+
+	c := &vppClient{client: srv.Client(), BaseURL: u, Config: config}
+	c.serviceConfig = serviceConfigCache{ttl: DefaultServiceConfigTTL, fetchedAt: time.Now(), config: &ServiceConfig{
+		GetUsersSrvURL:                  srv.URL + "/getVPPUsersSrv",
+		GetLicensesSrvURL:               srv.URL + "/getVPPLicensesSrv",
+		ManageVPPLicensesByAdamIdSrvURL: srv.URL + "/manageVPPLicensesByAdamIdSrv",
+	}}
+	c.configService = configService{client: c}
+	c.assetsService = assetsService{client: c}
+	c.licensesService = licensesService{client: c}
+	c.metadataService = metadataService{client: c}
+	c.usersService = usersService{client: c}
+
+	return c
+}
+
+func TestVppClient_DoWithContext_Canceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := newTestClient(srv)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.GetUsersWithContext(ctx, &BatchRequest{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled request, got %v", err)
+	}
+}
+
+// Traffic recorded with vpptest.Recorder can be replayed through the service methods without the VPP service.
+func TestVppClient_ReplayCassette(t *testing.T) {
+	setup()
+
+	recorder := vpptest.NewRecorder(nil)
+	vppClient, err := NewVPPClient(config, WithTransport(recorder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := vppClient.GetLicenses(&BatchRequest{}, ForAdamID("408709785"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown()
+
+	replayer := vpptest.NewReplayer(recorder.Cassette())
+	vppClient, err = NewVPPClient(&Config{URL: vppSimURL, SToken: "redacted"}, WithTransport(replayer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := vppClient.GetLicenses(&BatchRequest{}, ForAdamID("408709785"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) || replayed[0].LicenseID != recorded[0].LicenseID {
+		t.Errorf("expected the recorded licenses %v, got %v", recorded, replayed)
+	}
+
+	if _, err := vppClient.GetLicenses(&BatchRequest{}, ForAdamID("361309726")); err == nil {
+		t.Error("expected an error for a request which was not recorded")
+	}
 }
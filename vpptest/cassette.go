@@ -0,0 +1,265 @@
+package vpptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mosen/vpp/internal/redact"
+)
+
+// redactedHeaders lists the response headers which are not recorded, since they may carry credentials.
+var redactedHeaders = []string{"Set-Cookie", "Cookie", "Authorization", "Proxy-Authorization", "WWW-Authenticate"}
+
+// Cassette is a recording of the requests sent to the VPP service and the responses given, which can be saved to a
+// file and replayed as a test fixture.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a single recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request sent to the VPP service, with the sToken and user details redacted from its body.
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response given by the VPP service, with cookies and user details redacted.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// LoadCassette reads a cassette from a JSON file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette := &Cassette{}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to a JSON file.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Recorder is an http.RoundTripper which records every request and response passing through it to a cassette.
+// The sToken, and the email addresses and invitations of users, are redacted from the bodies of requests and
+// responses, and cookie and authorization headers are not recorded, so that cassettes can be kept as test fixtures.
+// Give it to vpp.WithTransport to capture the traffic of a client, then Save the cassette:
+//
+//	recorder := vpptest.NewRecorder(nil)
+//	client, err := vpp.NewVPPClient(config, vpp.WithTransport(recorder))
+//	...
+//	err = recorder.Cassette().Save("testdata/issue.json")
+type Recorder struct {
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder creates a Recorder sending requests with the given transport, or http.DefaultTransport if it is nil.
+func NewRecorder(transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{transport: transport}
+}
+
+// RoundTrip sends the request and records it along with its response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Body:   redactBody(requestBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     redactHeader(resp.Header),
+			Body:       redactBody(responseBody),
+		},
+	})
+	return resp, nil
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Replayer is an http.RoundTripper which serves the responses recorded in a cassette instead of sending requests.
+// Each recorded interaction is served once, to the first request with the same method, URL path and body. Fields
+// which are redacted from recordings, such as the sToken, are ignored.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer creates a Replayer serving the interactions of the cassette.
+func NewReplayer(cassette *Cassette) *Replayer {
+	return &Replayer{
+		interactions: cassette.Interactions,
+		used:         make([]bool, len(cassette.Interactions)),
+	}
+}
+
+// RoundTrip responds with the recorded response of the first unused interaction matching the request, or returns an
+// error if there is none.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	body := redactBody(requestBody)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.interactions {
+		if r.used[i] || !interaction.matches(req, body) {
+			continue
+		}
+		r.used[i] = true
+
+		recorded := interaction.Response
+		header := recorded.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("vpptest: no recorded interaction for %s %s", req.Method, req.URL)
+}
+
+// Unused returns the interactions which have not been replayed, eg. to check that a test made every request.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, interaction := range r.interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+func (i Interaction) matches(req *http.Request, body string) bool {
+	if i.Request.Method != req.Method {
+		return false
+	}
+
+	recorded, err := req.URL.Parse(i.Request.URL)
+	if err != nil || recorded.Path != req.URL.Path {
+		return false
+	}
+
+	return equalBodies(i.Request.Body, body)
+}
+
+// equalBodies compares JSON bodies regardless of formatting and the order of fields.
+func equalBodies(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	var av, bv interface{}
+	if json.Unmarshal([]byte(a), &av) != nil || json.Unmarshal([]byte(b), &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// readRequestBody reads the body of a request, replacing it so that the request can still be sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// redactBody replaces the values of sensitive fields of a JSON body. Bodies which are not JSON are returned as they
+// are.
+func redactBody(body []byte) string {
+	redacted, err := redact.JSON(body)
+	if err != nil {
+		return string(body)
+	}
+	return string(redacted)
+}
+
+// redactHeader returns a copy of a header without the headers which may carry credentials.
+func redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		header.Del(name)
+	}
+	return header
+}
@@ -0,0 +1,102 @@
+package vpptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_Replayer(t *testing.T) {
+	srv := NewServer()
+	srv.AddUser("abc", "abc@localhost")
+
+	recorder := NewRecorder(nil)
+	client := &http.Client{Transport: recorder}
+
+	send := func(client *http.Client, sToken string) (int, string) {
+		body, _ := json.Marshal(map[string]interface{}{"sToken": sToken, "includeRetired": 1})
+		resp, err := client.Post(srv.URL+"/getVPPUsersSrv", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	statusCode, recorded := send(client, srv.SToken())
+	srv.Close()
+
+	dir, err := ioutil.TempDir("", "vpptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cassette.json")
+	if err := recorder.Cassette().Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	for _, secret := range []string{srv.SToken(), "abc@localhost"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected %q to be redacted from the cassette", secret)
+		}
+	}
+
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer := NewReplayer(cassette)
+	client = &http.Client{Transport: replayer}
+
+	// The server is closed, so the response can only come from the cassette.
+	replayedStatusCode, replayed := send(client, "another token")
+	if replayedStatusCode != statusCode || !equalBodies(replayed, redactBody([]byte(recorded))) {
+		t.Errorf("expected the recorded response %d %s, got %d %s", statusCode, recorded, replayedStatusCode, replayed)
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("expected every interaction to be replayed, got %#v", unused)
+	}
+
+	if _, err := client.Post(srv.URL+"/getVPPUsersSrv", "application/json", strings.NewReader(`{}`)); err == nil {
+		t.Error("expected an error for a request which was not recorded")
+	}
+}
+
+func TestRecorder_RedactsHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":0,"user":{"email":"abc@localhost","inviteCode":"secret","clientUserIdStr":"abc"}}`))
+	}))
+	defer srv.Close()
+
+	recorder := NewRecorder(nil)
+	resp, err := (&http.Client{Transport: recorder}).Get(srv.URL + "/getVPPUserSrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(resp.Cookies()) != 1 {
+		t.Error("expected the response given to the client not to be redacted")
+	}
+
+	recorded := recorder.Cassette().Interactions[0].Response
+	if recorded.Header.Get("Set-Cookie") != "" || recorded.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected only the cookie to be removed from the recorded header, got %v", recorded.Header)
+	}
+	if strings.Contains(recorded.Body, "secret") || strings.Contains(recorded.Body, "abc@localhost") ||
+		!strings.Contains(recorded.Body, `"clientUserIdStr":"abc"`) {
+		t.Errorf("expected the user details to be redacted from the recorded body, got %s", recorded.Body)
+	}
+}
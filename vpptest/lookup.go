@@ -0,0 +1,57 @@
+package vpptest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const lookupEndpoint = "lookup"
+
+// StoreItem is an app or book known to the fake lookup endpoint, described as it is by the iTunes lookup API.
+type StoreItem struct {
+	TrackID          int      `json:"trackId"`
+	TrackName        string   `json:"trackName"`
+	Kind             string   `json:"kind"`
+	BundleID         string   `json:"bundleId,omitempty"`
+	Version          string   `json:"version,omitempty"`
+	SupportedDevices []string `json:"supportedDevices,omitempty"`
+	ArtworkURL100    string   `json:"artworkUrl100,omitempty"`
+	ArtworkURL512    string   `json:"artworkUrl512,omitempty"`
+	Price            float64  `json:"price"`
+	FormattedPrice   string   `json:"formattedPrice,omitempty"`
+	Currency         string   `json:"currency,omitempty"`
+}
+
+// LookupURL returns the URL of the fake lookup endpoint.
+func (s *Server) LookupURL() string {
+	return s.URL + "/" + lookupEndpoint
+}
+
+// AddStoreItem makes an app or book known to the lookup endpoint.
+func (s *Server) AddStoreItem(item StoreItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.storeItems[item.TrackID] = item
+}
+
+// serveLookup responds to a lookup of comma separated ids with the store items that are known, ignoring the others
+// as the iTunes lookup API does.
+func (s *Server) serveLookup(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := []StoreItem{}
+	for _, id := range strings.Split(r.URL.Query().Get("id"), ",") {
+		trackID, err := strconv.Atoi(strings.TrimSpace(id))
+		if err != nil {
+			continue
+		}
+		if item, ok := s.storeItems[trackID]; ok {
+			results = append(results, item)
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{"resultCount": len(results), "results": results})
+}
@@ -0,0 +1,215 @@
+package vpptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response kinds that may be given in a ResponseFault.
+const (
+	// ResponseOK serves the request normally, which is useful in combination with a delay.
+	ResponseOK = "ok"
+
+	// ResponseServiceUnavailable responds with a 503, and a Retry-After header if one is given.
+	ResponseServiceUnavailable = "service_unavailable"
+
+	// ResponseRedirect responds with a 307, and a Retry-After header if one is given.
+	ResponseRedirect = "redirect"
+
+	// ResponseServerError responds with the given status code, or a 500.
+	ResponseServerError = "server_error"
+
+	// ResponseMalformed responds with a 200 and a body that is not valid JSON.
+	ResponseMalformed = "malformed"
+
+	// ResponseVPPError responds with a 200 and a VPP error status, using the given error number and message.
+	ResponseVPPError = "vpp_error"
+)
+
+// Scenario describes faults and events to be played by the fake server.
+// It is read from the same JSON format as the scenario files of vppsim, where each "<endpoint>_responses" key lists
+// the faults to apply to requests for that endpoint, eg. "getVPPUsersSrv_responses".
+type Scenario struct {
+	LicenseInsertions []LicenseInsertion         `json:"license_insertions,omitempty"`
+	Responses         map[string][]ResponseFault `json:"-"`
+}
+
+// LicenseInsertion describes licenses being purchased over time.
+type LicenseInsertion struct {
+	InitialDelayInSeconds float64 `json:"initial_delay_in_seconds"`
+	IntervalInSeconds     float64 `json:"interval_in_seconds"`
+	License               struct {
+		AdamID       int    `json:"adamId"`
+		PricingParam string `json:"pricingParam,omitempty"`
+	} `json:"license"`
+	LicensesPerEvent int `json:"licenses_per_event"`
+	MaxEventCount    int `json:"max_event_count"`
+}
+
+// ResponseFault replaces the normal response of an endpoint.
+type ResponseFault struct {
+	// Count is the number of requests that the fault applies to. Zero applies it to every request.
+	Count int `json:"count"`
+
+	// After is the number of requests that are served normally before the fault is applied.
+	After int `json:"after,omitempty"`
+
+	// Response is the kind of response to give, eg. ResponseServiceUnavailable.
+	Response string `json:"response"`
+
+	// DelayInMilliseconds delays the response, to simulate latency.
+	DelayInMilliseconds int `json:"delay_in_ms,omitempty"`
+
+	RetryAfter   string `json:"retry_after,omitempty"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	ErrorNumber  int    `json:"error_number,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// UnmarshalJSON reads the license insertions and every "<endpoint>_responses" list of a scenario.
+func (sc *Scenario) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	sc.Responses = make(map[string][]ResponseFault)
+	for key, value := range raw {
+		switch {
+		case key == "license_insertions":
+			if err := json.Unmarshal(value, &sc.LicenseInsertions); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		case strings.HasSuffix(key, "_responses"):
+			var faults []ResponseFault
+			if err := json.Unmarshal(value, &faults); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			sc.Responses[strings.TrimSuffix(key, "_responses")] = faults
+		default:
+			return fmt.Errorf("unknown scenario key %q", key)
+		}
+	}
+
+	return nil
+}
+
+// LoadScenario reads a scenario from a JSON file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := &Scenario{}
+	if err := json.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// faultState tracks how much of a fault has been applied.
+type faultState struct {
+	ResponseFault
+	passed  int
+	applied int
+}
+
+// Play applies the faults of the scenario to subsequent requests, after any faults already playing, and starts
+// inserting licenses. License insertions stop when the server is closed.
+func (s *Server) Play(scenario *Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for endpoint, faults := range scenario.Responses {
+		for _, fault := range faults {
+			s.faults[endpoint] = append(s.faults[endpoint], &faultState{ResponseFault: fault})
+		}
+	}
+
+	for _, insertion := range scenario.LicenseInsertions {
+		go s.insertLicenses(insertion)
+	}
+}
+
+func (s *Server) insertLicenses(insertion LicenseInsertion) {
+	asset := Asset{AdamIDStr: strconv.Itoa(insertion.License.AdamID), PricingParam: insertion.License.PricingParam}
+	delay := seconds(insertion.InitialDelayInSeconds)
+
+	for event := 0; insertion.MaxEventCount == 0 || event < insertion.MaxEventCount; event++ {
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(delay):
+		}
+
+		s.AddLicenses(asset, insertion.LicensesPerEvent)
+		delay = seconds(insertion.IntervalInSeconds)
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// nextFault returns the fault to apply to the next request for the endpoint, if any.
+// The server must be locked by the caller.
+func (s *Server) nextFault(endpoint string) *ResponseFault {
+	faults := s.faults[endpoint]
+	if len(faults) == 0 {
+		return nil
+	}
+
+	fault := faults[0]
+	if fault.passed < fault.After {
+		fault.passed++
+		return nil
+	}
+
+	fault.applied++
+	if fault.Count != 0 && fault.applied >= fault.Count {
+		s.faults[endpoint] = faults[1:]
+	}
+	return &fault.ResponseFault
+}
+
+// serveFault writes the response of a fault, returning false if the request should be served normally.
+func serveFault(w http.ResponseWriter, fault *ResponseFault) bool {
+	if fault.DelayInMilliseconds > 0 {
+		time.Sleep(time.Duration(fault.DelayInMilliseconds) * time.Millisecond)
+	}
+
+	if fault.RetryAfter != "" {
+		w.Header().Set("Retry-After", fault.RetryAfter)
+	}
+
+	switch fault.Response {
+	case ResponseServiceUnavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case ResponseRedirect:
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	case ResponseServerError:
+		statusCode := fault.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		w.WriteHeader(statusCode)
+	case ResponseMalformed:
+		w.Write([]byte(`{"status":0,"users":[`))
+	case ResponseVPPError:
+		response := withError(map[string]interface{}{"status": -1}, fault.ErrorNumber)
+		if fault.ErrorMessage != "" {
+			response["errorMessage"] = fault.ErrorMessage
+		}
+		writeJSON(w, response)
+	default:
+		return false
+	}
+
+	return true
+}
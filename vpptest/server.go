@@ -0,0 +1,844 @@
+// Package vpptest provides an in-process fake of Apple's VPP service for use in tests.
+//
+// The server implements the service configuration, user, license, asset and client configuration endpoints against
+// in-memory state, and mints sTokens that it will accept. It also stands in for the iTunes lookup endpoint, serving
+// the store items added to it:
+//
+//	srv := vpptest.NewServer()
+//	defer srv.Close()
+//
+//	u, _ := url.Parse(srv.URL)
+//	client, err := vpp.NewVPPClient(&vpp.Config{URL: u, SToken: srv.SToken()})
+//
+// Traffic with the real VPP service can be captured with a Recorder and served back by a Replayer, to turn a field
+// issue into a regression test.
+package vpptest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is the number of users or licenses returned in each batch unless Server.BatchSize is set.
+	DefaultBatchSize = 100
+
+	sTokenExpDateLayout = "2006-01-02T15:04:05-0700"
+)
+
+// Error numbers returned by the fake server, matching those of the VPP service.
+const (
+	errorNumberMissingArgument        = 9600
+	errorNumberInvalidArgument        = 9602
+	errorNumberLicenseIrrevocable     = 9607
+	errorNumberUserNotFound           = 9609
+	errorNumberLicenseNotFound        = 9610
+	errorNumberLicenseAlreadyAssigned = 9616
+	errorNumberUserAlreadyRetired     = 9618
+	errorNumberLicenseNotAssociated   = 9619
+	errorNumberSTokenExpired          = 9621
+	errorNumberInvalidSToken          = 9622
+)
+
+var errorMessages = map[int]string{
+	errorNumberMissingArgument:        "Missing argument",
+	errorNumberInvalidArgument:        "Invalid argument",
+	errorNumberLicenseIrrevocable:     "License is irrevocable",
+	errorNumberUserNotFound:           "Registered user not found",
+	errorNumberLicenseNotFound:        "License not found",
+	errorNumberLicenseAlreadyAssigned: "License already assigned",
+	errorNumberUserAlreadyRetired:     "User already retired",
+	errorNumberLicenseNotAssociated:   "License not associated",
+	errorNumberSTokenExpired:          "The sToken has expired",
+	errorNumberInvalidSToken:          "Invalid authentication token",
+}
+
+// User is a VPP user known to the fake server.
+type User struct {
+	UserID          int    `json:"userId"`
+	Email           string `json:"email,omitempty"`
+	Status          string `json:"status"`
+	InviteURL       string `json:"inviteUrl,omitempty"`
+	InviteCode      string `json:"inviteCode,omitempty"`
+	ClientUserIDStr string `json:"clientUserIdStr"`
+	ITSIDHash       string `json:"itsIdHash,omitempty"`
+
+	modified int
+}
+
+// Asset is an app or book that licenses can be purchased for.
+type Asset struct {
+	AdamIDStr        string `json:"adamIdStr"`
+	PricingParam     string `json:"pricingParam"`
+	ProductTypeID    int    `json:"productTypeId"`
+	ProductTypeName  string `json:"productTypeName"`
+	IsIrrevocable    bool   `json:"isIrrevocable"`
+	DeviceAssignable bool   `json:"deviceAssignable"`
+}
+
+// License is a single license for an asset, which may be associated with a user or a device serial number.
+type License struct {
+	LicenseIDStr string `json:"licenseIdStr"`
+	Asset
+	Status          string `json:"status"`
+	ClientUserIDStr string `json:"clientUserIdStr,omitempty"`
+	UserID          int    `json:"userId,omitempty"`
+	ITSIDHash       string `json:"itsIdHash,omitempty"`
+	SerialNumber    string `json:"serialNumber,omitempty"`
+
+	modified int
+}
+
+// Assigned reports whether the license is associated with a user or device.
+func (l *License) Assigned() bool {
+	return l.ClientUserIDStr != "" || l.SerialNumber != ""
+}
+
+// Server is a fake VPP service.
+type Server struct {
+	*httptest.Server
+
+	// BatchSize is the number of users or licenses returned in each batch.
+	BatchSize int
+
+	// MaxBatchAssociateLicenseCount and MaxBatchDisassociateLicenseCount are published in the service config
+	// and enforced by manageVPPLicensesByAdamIdSrv.
+	MaxBatchAssociateLicenseCount    int
+	MaxBatchDisassociateLicenseCount int
+
+	// CountryCode is returned by the client config endpoint.
+	CountryCode string
+
+	mu            sync.Mutex
+	sTokens       map[string]time.Time
+	sToken        string
+	users         []*User
+	licenses      []*License
+	assets        map[string]Asset
+	storeItems    map[int]StoreItem
+	clientContext string
+	seq           int
+	requests      map[string]int
+	handlers      map[string]handlerFunc
+	faults        map[string][]*faultState
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewServer starts a fake VPP service, which must be closed when the test is done.
+func NewServer() *Server {
+	s := &Server{
+		BatchSize:                        DefaultBatchSize,
+		MaxBatchAssociateLicenseCount:    10000,
+		MaxBatchDisassociateLicenseCount: 10000,
+		CountryCode:                      "US",
+		sTokens:                          make(map[string]time.Time),
+		assets:                           make(map[string]Asset),
+		storeItems:                       make(map[int]StoreItem),
+		requests:                         make(map[string]int),
+		faults:                           make(map[string][]*faultState),
+		closed:                           make(chan struct{}),
+	}
+	s.handlers = map[string]handlerFunc{
+		"registerVPPUserSrv":                   s.handleRegisterUser,
+		"getVPPUserSrv":                        s.handleGetUser,
+		"getVPPUsersSrv":                       s.handleGetUsers,
+		"retireVPPUserSrv":                     s.handleRetireUser,
+		"editVPPUserSrv":                       s.handleEditUser,
+		"getVPPLicensesSrv":                    s.handleGetLicenses,
+		"manageVPPLicensesByAdamIdSrv":         s.handleManageLicenses,
+		"associateVPPLicenseWithVPPUserSrv":    s.handleAssociateLicense,
+		"disassociateVPPLicenseFromVPPUserSrv": s.handleDisassociateLicense,
+		"getVPPAssetsSrv":                      s.handleGetAssets,
+		"VPPClientConfigSrv":                   s.handleClientConfig,
+	}
+	s.Server = httptest.NewServer(s)
+	s.sToken = s.MintSToken("Example Inc.", time.Now().AddDate(1, 0, 0))
+	return s
+}
+
+// Close stops any scenario being played and shuts down the server.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	s.Server.Close()
+}
+
+// SToken returns a valid sToken, accepted by the server until it expires in a year.
+func (s *Server) SToken() string {
+	return s.sToken
+}
+
+// MintSToken creates a base64 encoded sToken for the given organisation, which the server will accept until the
+// given expiry date. Tokens that have already expired are rejected with the error for an expired token.
+func (s *Server) MintSToken(orgName string, expires time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, _ := json.Marshal(map[string]string{
+		"token":   base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("vpptest-%d-%d", len(s.sTokens), time.Now().UnixNano()))),
+		"expDate": expires.Format(sTokenExpDateLayout),
+		"orgName": orgName,
+	})
+	encoded := base64.StdEncoding.EncodeToString(token)
+	s.sTokens[encoded] = expires
+	return encoded
+}
+
+// RevokeSToken stops the server from accepting the given token.
+func (s *Server) RevokeSToken(sToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sTokens, sToken)
+}
+
+// AddUser registers a user, as if registerVPPUserSrv had been called.
+func (s *Server) AddUser(clientUserIDStr, email string) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.registerUser(clientUserIDStr, email)
+}
+
+// AddLicenses purchases count licenses of the given asset, returning their license ids.
+func (s *Server) AddLicenses(asset Asset, count int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addLicenses(asset, count)
+}
+
+// Users returns a copy of every user known to the server.
+func (s *Server) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, len(s.users))
+	for i, user := range s.users {
+		users[i] = *user
+	}
+	return users
+}
+
+// Licenses returns a copy of every license known to the server.
+func (s *Server) Licenses() []License {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	licenses := make([]License, len(s.licenses))
+	for i, license := range s.licenses {
+		licenses[i] = *license
+	}
+	return licenses
+}
+
+// ClientContext returns the client context most recently stored with the server.
+func (s *Server) ClientContext() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clientContext
+}
+
+// Requests returns the number of requests received by the named endpoint, eg. "getVPPUsersSrv".
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[endpoint]
+}
+
+// ServeHTTP dispatches a request to the endpoint named by the last element of its path, unless a fault from a
+// scenario applies to it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	s.mu.Lock()
+	s.requests[endpoint]++
+	fault := s.nextFault(endpoint)
+	s.mu.Unlock()
+
+	if fault != nil && serveFault(w, fault) {
+		return
+	}
+
+	if endpoint == "VPPServiceConfigSrv" {
+		writeJSON(w, s.serviceConfig())
+		return
+	}
+
+	if endpoint == lookupEndpoint {
+		s.serveLookup(w, r)
+		return
+	}
+
+	handler, ok := s.handlers[endpoint]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var request map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if errorNumber := s.checkSToken(stringArg(request, "sToken")); errorNumber != 0 {
+		writeJSON(w, errorResponse(errorNumber))
+		return
+	}
+
+	writeJSON(w, handler(request))
+}
+
+type handlerFunc func(request map[string]interface{}) interface{}
+
+func (s *Server) serviceConfig() map[string]interface{} {
+	url := func(endpoint string) string {
+		return s.URL + "/" + endpoint
+	}
+
+	var errorCodes []map[string]interface{}
+	for number, message := range errorMessages {
+		errorCodes = append(errorCodes, map[string]interface{}{"errorNumber": number, "errorMessage": message})
+	}
+
+	return map[string]interface{}{
+		"invitationEmailUrl":               url("invitation?inviteCode=%inviteCode%"),
+		"registerUserSrvUrl":               url("registerVPPUserSrv"),
+		"editUserSrvUrl":                   url("editVPPUserSrv"),
+		"getUserSrvUrl":                    url("getVPPUserSrv"),
+		"retireUserSrvUrl":                 url("retireVPPUserSrv"),
+		"getUsersSrvUrl":                   url("getVPPUsersSrv"),
+		"getLicensesSrvUrl":                url("getVPPLicensesSrv"),
+		"associateLicenseSrvUrl":           url("associateVPPLicenseWithVPPUserSrv"),
+		"disassociateLicenseSrvUrl":        url("disassociateVPPLicenseFromVPPUserSrv"),
+		"clientConfigSrvUrl":               url("VPPClientConfigSrv"),
+		"getVPPAssetsSrvUrl":               url("getVPPAssetsSrv"),
+		"manageVPPLicensesByAdamIdSrvUrl":  url("manageVPPLicensesByAdamIdSrv"),
+		"maxBatchAssociateLicenseCount":    s.MaxBatchAssociateLicenseCount,
+		"maxBatchDisassociateLicenseCount": s.MaxBatchDisassociateLicenseCount,
+		"errorCodes":                       errorCodes,
+		"status":                           0,
+	}
+}
+
+func (s *Server) checkSToken(sToken string) int {
+	if sToken == "" {
+		return errorNumberMissingArgument
+	}
+
+	expires, ok := s.sTokens[sToken]
+	switch {
+	case !ok:
+		return errorNumberInvalidSToken
+	case time.Now().After(expires):
+		return errorNumberSTokenExpired
+	default:
+		return 0
+	}
+}
+
+func (s *Server) modified() int {
+	s.seq++
+	return s.seq
+}
+
+func (s *Server) registerUser(clientUserIDStr, email string) *User {
+	if user := s.findUser(0, clientUserIDStr); user != nil && user.Status != "Retired" {
+		return user
+	}
+
+	userID := len(s.users) + 1
+	inviteCode := fmt.Sprintf("%032x", userID)
+	user := &User{
+		UserID:          userID,
+		Email:           email,
+		Status:          "Registered",
+		InviteCode:      inviteCode,
+		InviteURL:       s.URL + "/invitation?inviteCode=" + inviteCode,
+		ClientUserIDStr: clientUserIDStr,
+		modified:        s.modified(),
+	}
+	s.users = append(s.users, user)
+	return user
+}
+
+func (s *Server) findUser(userID int, clientUserIDStr string) *User {
+	for i := len(s.users) - 1; i >= 0; i-- {
+		user := s.users[i]
+		if (userID != 0 && user.UserID == userID) || (clientUserIDStr != "" && user.ClientUserIDStr == clientUserIDStr) {
+			return user
+		}
+	}
+	return nil
+}
+
+func (s *Server) addLicenses(asset Asset, count int) []string {
+	if asset.PricingParam == "" {
+		asset.PricingParam = "STDQ"
+	}
+	if asset.ProductTypeID == 0 {
+		asset.ProductTypeID = 8
+		asset.ProductTypeName = "Software"
+	}
+	s.assets[asset.AdamIDStr+asset.PricingParam] = asset
+
+	var ids []string
+	for i := 0; i < count; i++ {
+		license := &License{
+			LicenseIDStr: strconv.Itoa(len(s.licenses) + 1),
+			Asset:        asset,
+			Status:       "Available",
+			modified:     s.modified(),
+		}
+		s.licenses = append(s.licenses, license)
+		ids = append(ids, license.LicenseIDStr)
+	}
+	return ids
+}
+
+func (s *Server) handleRegisterUser(request map[string]interface{}) interface{} {
+	clientUserIDStr := stringArg(request, "clientUserIdStr")
+	if clientUserIDStr == "" {
+		return errorResponse(errorNumberMissingArgument)
+	}
+
+	user := s.registerUser(clientUserIDStr, stringArg(request, "email"))
+	return map[string]interface{}{"status": 0, "user": user}
+}
+
+func (s *Server) handleGetUser(request map[string]interface{}) interface{} {
+	user := s.findUser(intArg(request, "userId"), stringArg(request, "clientUserIdStr"))
+	if user == nil {
+		return errorResponse(errorNumberUserNotFound)
+	}
+	return map[string]interface{}{"status": 0, "user": user}
+}
+
+func (s *Server) handleGetUsers(request map[string]interface{}) interface{} {
+	includeRetired := intArg(request, "includeRetired") == 1
+
+	var users []interface{}
+	for _, user := range s.users {
+		if includeRetired || user.Status != "Retired" {
+			users = append(users, user)
+		}
+	}
+
+	return s.batch(request, "users", users, func(i int) int {
+		return users[i].(*User).modified
+	})
+}
+
+func (s *Server) handleRetireUser(request map[string]interface{}) interface{} {
+	user := s.findUser(intArg(request, "userId"), stringArg(request, "clientUserIdStr"))
+	if user == nil {
+		return errorResponse(errorNumberUserNotFound)
+	}
+	if user.Status == "Retired" {
+		return errorResponse(errorNumberUserAlreadyRetired)
+	}
+
+	user.Status = "Retired"
+	user.modified = s.modified()
+	for _, license := range s.licenses {
+		if license.ClientUserIDStr == user.ClientUserIDStr && !license.IsIrrevocable {
+			s.freeLicense(license)
+		}
+	}
+
+	return map[string]interface{}{"status": 0, "user": user}
+}
+
+func (s *Server) handleEditUser(request map[string]interface{}) interface{} {
+	user := s.findUser(intArg(request, "userId"), stringArg(request, "clientUserIdStr"))
+	if user == nil {
+		return errorResponse(errorNumberUserNotFound)
+	}
+
+	user.Email = stringArg(request, "email")
+	user.modified = s.modified()
+	return map[string]interface{}{"status": 0, "user": user}
+}
+
+func (s *Server) handleGetLicenses(request map[string]interface{}) interface{} {
+	assignedOnly, _ := request["assignedOnly"].(bool)
+	adamID := intArg(request, "adamId")
+	pricingParam := stringArg(request, "pricingParam")
+	serialNumber := stringArg(request, "serialNumber")
+	clientUserIDStr := stringArg(request, "clientUserIdStr")
+
+	var licenses []interface{}
+	for _, license := range s.licenses {
+		switch {
+		case assignedOnly && !license.Assigned():
+		case adamID != 0 && license.AdamIDStr != strconv.Itoa(adamID):
+		case pricingParam != "" && license.PricingParam != pricingParam:
+		case serialNumber != "" && license.SerialNumber != serialNumber:
+		case clientUserIDStr != "" && license.ClientUserIDStr != clientUserIDStr:
+		default:
+			licenses = append(licenses, license)
+		}
+	}
+
+	return s.batch(request, "licenses", licenses, func(i int) int {
+		return licenses[i].(*License).modified
+	})
+}
+
+// batch returns a single batch of records, following the batchToken and sinceModifiedToken of the request.
+// Batch tokens encode the offset into the records along with the modification sequence number that the request
+// started from, and the sequence number at which the first batch was served.
+func (s *Server) batch(request map[string]interface{}, key string, records []interface{}, modified func(int) int) interface{} {
+	offset, since, snapshot := 0, 0, s.seq
+	if batchToken := stringArg(request, "batchToken"); batchToken != "" {
+		if _, err := fmt.Sscanf(batchToken, "%d.%d.%d", &offset, &since, &snapshot); err != nil {
+			return errorResponse(errorNumberInvalidArgument)
+		}
+	} else if sinceModifiedToken := stringArg(request, "sinceModifiedToken"); sinceModifiedToken != "" {
+		var err error
+		if since, err = strconv.Atoi(sinceModifiedToken); err != nil {
+			return errorResponse(errorNumberInvalidArgument)
+		}
+	}
+
+	var matching []interface{}
+	for i, record := range records {
+		if modified(i) > since {
+			matching = append(matching, record)
+		}
+	}
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	end := offset + batchSize
+	if end > len(matching) {
+		end = len(matching)
+	}
+	if offset > end {
+		offset = end
+	}
+
+	response := map[string]interface{}{"status": 0, key: matching[offset:end]}
+	if offset == 0 {
+		response["totalCount"] = len(matching)
+		response["totalBatchCount"] = (len(matching) + batchSize - 1) / batchSize
+	}
+	if end < len(matching) {
+		response["batchToken"] = fmt.Sprintf("%d.%d.%d", end, since, snapshot)
+	} else {
+		response["sinceModifiedToken"] = strconv.Itoa(snapshot)
+	}
+	return response
+}
+
+func (s *Server) handleManageLicenses(request map[string]interface{}) interface{} {
+	adamIDStr := stringArg(request, "adamIdStr")
+	pricingParam := stringArg(request, "pricingParam")
+	if pricingParam == "" {
+		pricingParam = "STDQ"
+	}
+
+	asset, ok := s.assets[adamIDStr+pricingParam]
+	if !ok {
+		return errorResponse(errorNumberLicenseNotFound)
+	}
+
+	// Batches larger than the limits published in the service config are rejected as a whole.
+	associateCount := len(stringsArg(request, "associateSerialNumbers")) + len(stringsArg(request, "associateClientUserIdStrs"))
+	disassociateCount := len(stringsArg(request, "disassociateSerialNumbers")) +
+		len(stringsArg(request, "disassociateClientUserIdStrs")) + len(stringsArg(request, "disassociateLicenseIdStrs"))
+	if associateCount > s.MaxBatchAssociateLicenseCount || disassociateCount > s.MaxBatchDisassociateLicenseCount {
+		return errorResponse(errorNumberInvalidArgument)
+	}
+
+	var associations, disassociations []map[string]interface{}
+	for _, serialNumber := range stringsArg(request, "associateSerialNumbers") {
+		if !asset.DeviceAssignable {
+			associations = append(associations, withError(map[string]interface{}{"serialNumber": serialNumber}, errorNumberInvalidArgument))
+			continue
+		}
+		associations = append(associations, s.associate(asset, "", serialNumber))
+	}
+	for _, clientUserIDStr := range stringsArg(request, "associateClientUserIdStrs") {
+		associations = append(associations, s.associate(asset, clientUserIDStr, ""))
+	}
+
+	for _, serialNumber := range stringsArg(request, "disassociateSerialNumbers") {
+		disassociations = append(disassociations, s.disassociate(asset, func(l *License) bool {
+			return l.SerialNumber == serialNumber
+		}, map[string]interface{}{"serialNumber": serialNumber}))
+	}
+	for _, clientUserIDStr := range stringsArg(request, "disassociateClientUserIdStrs") {
+		disassociations = append(disassociations, s.disassociate(asset, func(l *License) bool {
+			return l.ClientUserIDStr == clientUserIDStr
+		}, map[string]interface{}{"clientUserIdStr": clientUserIDStr}))
+	}
+	for _, licenseIDStr := range stringsArg(request, "disassociateLicenseIdStrs") {
+		disassociations = append(disassociations, s.disassociate(asset, func(l *License) bool {
+			return l.LicenseIDStr == licenseIDStr && l.Assigned()
+		}, map[string]interface{}{"licenseIdStr": licenseIDStr}))
+	}
+
+	return map[string]interface{}{
+		"status":          0,
+		"adamIdStr":       asset.AdamIDStr,
+		"pricingParam":    asset.PricingParam,
+		"productTypeId":   asset.ProductTypeID,
+		"productTypeName": asset.ProductTypeName,
+		"isIrrevocable":   asset.IsIrrevocable,
+		"associations":    associations,
+		"disassociations": disassociations,
+	}
+}
+
+func (s *Server) associate(asset Asset, clientUserIDStr, serialNumber string) map[string]interface{} {
+	result := map[string]interface{}{}
+	if clientUserIDStr != "" {
+		result["clientUserIdStr"] = clientUserIDStr
+	} else {
+		result["serialNumber"] = serialNumber
+	}
+
+	var user *User
+	if clientUserIDStr != "" {
+		if user = s.findUser(0, clientUserIDStr); user == nil || user.Status == "Retired" {
+			return withError(result, errorNumberUserNotFound)
+		}
+	}
+
+	var free *License
+	for _, license := range s.licenses {
+		if license.AdamIDStr != asset.AdamIDStr || license.PricingParam != asset.PricingParam {
+			continue
+		}
+		if (clientUserIDStr != "" && license.ClientUserIDStr == clientUserIDStr) ||
+			(serialNumber != "" && license.SerialNumber == serialNumber) {
+			return withError(result, errorNumberLicenseAlreadyAssigned)
+		}
+		if free == nil && !license.Assigned() {
+			free = license
+		}
+	}
+
+	if free == nil {
+		return withError(result, errorNumberLicenseNotFound)
+	}
+
+	free.Status = "Associated"
+	free.SerialNumber = serialNumber
+	if user != nil {
+		free.ClientUserIDStr = user.ClientUserIDStr
+		free.UserID = user.UserID
+		free.ITSIDHash = user.ITSIDHash
+	}
+	free.modified = s.modified()
+
+	result["licenseIdStr"] = free.LicenseIDStr
+	return result
+}
+
+func (s *Server) disassociate(asset Asset, match func(*License) bool, result map[string]interface{}) map[string]interface{} {
+	for _, license := range s.licenses {
+		if license.AdamIDStr != asset.AdamIDStr || license.PricingParam != asset.PricingParam || !match(license) {
+			continue
+		}
+		if license.IsIrrevocable {
+			return withError(result, errorNumberLicenseIrrevocable)
+		}
+
+		result["licenseIdStr"] = license.LicenseIDStr
+		s.freeLicense(license)
+		return result
+	}
+
+	return withError(result, errorNumberLicenseNotAssociated)
+}
+
+func (s *Server) freeLicense(license *License) {
+	license.Status = "Available"
+	license.ClientUserIDStr = ""
+	license.UserID = 0
+	license.ITSIDHash = ""
+	license.SerialNumber = ""
+	license.modified = s.modified()
+}
+
+func (s *Server) handleAssociateLicense(request map[string]interface{}) interface{} {
+	user := s.findUser(intArg(request, "userId"), stringArg(request, "clientUserIdStr"))
+	if user == nil || user.Status == "Retired" {
+		return errorResponse(errorNumberUserNotFound)
+	}
+
+	licenseID := stringArg(request, "licenseId")
+	adamID := stringArg(request, "adamId")
+	for _, license := range s.licenses {
+		if (licenseID != "" && license.LicenseIDStr == licenseID) || (licenseID == "" && license.AdamIDStr == adamID && !license.Assigned()) {
+			if license.Assigned() {
+				return errorResponse(errorNumberLicenseAlreadyAssigned)
+			}
+
+			license.Status = "Associated"
+			license.ClientUserIDStr = user.ClientUserIDStr
+			license.UserID = user.UserID
+			license.modified = s.modified()
+			if user.Status == "Registered" {
+				user.Status = "Associated"
+				user.modified = s.modified()
+			}
+			return map[string]interface{}{"status": 0, "license": license, "user": user}
+		}
+	}
+
+	return errorResponse(errorNumberLicenseNotFound)
+}
+
+func (s *Server) handleDisassociateLicense(request map[string]interface{}) interface{} {
+	licenseID := stringArg(request, "licenseId")
+	for _, license := range s.licenses {
+		if license.LicenseIDStr != licenseID {
+			continue
+		}
+		if !license.Assigned() {
+			return errorResponse(errorNumberLicenseNotAssociated)
+		}
+		if license.IsIrrevocable {
+			return errorResponse(errorNumberLicenseIrrevocable)
+		}
+
+		user := s.findUser(license.UserID, license.ClientUserIDStr)
+		s.freeLicense(license)
+		return map[string]interface{}{"status": 0, "license": license, "user": user}
+	}
+
+	return errorResponse(errorNumberLicenseNotFound)
+}
+
+func (s *Server) handleGetAssets(request map[string]interface{}) interface{} {
+	includeLicenseCounts, _ := request["includeLicenseCounts"].(bool)
+	pricingParam := stringArg(request, "pricingParam")
+	productTypeID, _ := request["productTypeId"].(float64)
+
+	var assets []map[string]interface{}
+	seen := make(map[string]bool)
+	for _, license := range s.licenses {
+		key := license.AdamIDStr + license.PricingParam
+		if seen[key] {
+			continue
+		}
+		if pricingParam != "" && license.PricingParam != pricingParam {
+			continue
+		}
+		if productTypeID != 0 && license.ProductTypeID != int(productTypeID) {
+			continue
+		}
+		seen[key] = true
+
+		asset := map[string]interface{}{
+			"adamIdStr":        license.AdamIDStr,
+			"pricingParam":     license.PricingParam,
+			"productTypeId":    license.ProductTypeID,
+			"productTypeName":  license.ProductTypeName,
+			"isIrrevocable":    license.IsIrrevocable,
+			"deviceAssignable": license.DeviceAssignable,
+		}
+
+		if includeLicenseCounts {
+			var total, assigned int
+			for _, other := range s.licenses {
+				if other.AdamIDStr+other.PricingParam == key {
+					total++
+					if other.Assigned() {
+						assigned++
+					}
+				}
+			}
+			asset["totalCount"] = total
+			asset["assignedCount"] = assigned
+			asset["availableCount"] = total - assigned
+			asset["retiredCount"] = 0
+		}
+
+		assets = append(assets, asset)
+	}
+
+	return map[string]interface{}{"status": 0, "assets": assets, "totalCount": len(assets)}
+}
+
+func (s *Server) handleClientConfig(request map[string]interface{}) interface{} {
+	if clientContext, ok := request["clientContext"].(string); ok {
+		s.clientContext = clientContext
+	}
+
+	return map[string]interface{}{
+		"status":        0,
+		"clientContext": s.clientContext,
+		"countryCode":   s.CountryCode,
+	}
+}
+
+func errorResponse(errorNumber int) map[string]interface{} {
+	return withError(map[string]interface{}{"status": -1}, errorNumber)
+}
+
+func withError(response map[string]interface{}, errorNumber int) map[string]interface{} {
+	response["errorNumber"] = errorNumber
+	response["errorMessage"] = errorMessages[errorNumber]
+	return response
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func stringArg(request map[string]interface{}, key string) string {
+	switch v := request[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func intArg(request map[string]interface{}, key string) int {
+	switch v := request[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	default:
+		return 0
+	}
+}
+
+func stringsArg(request map[string]interface{}, key string) []string {
+	values, _ := request[key].([]interface{})
+	var result []string
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
@@ -0,0 +1,236 @@
+package vpptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, srv *Server, endpoint string, request map[string]interface{}) map[string]interface{} {
+	body, _ := json.Marshal(request)
+	resp, err := http.Post(srv.URL+"/"+endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestServer_SToken(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tests := map[string]float64{
+		srv.SToken(): 0,
+		srv.MintSToken("Expired Inc.", time.Now().Add(-time.Hour)): errorNumberSTokenExpired,
+		"unknown": errorNumberInvalidSToken,
+	}
+
+	for sToken, errorNumber := range tests {
+		response := post(t, srv, "getVPPUsersSrv", map[string]interface{}{"sToken": sToken})
+		if number, _ := response["errorNumber"].(float64); number != errorNumber {
+			t.Errorf("expected error number %v, got %v", errorNumber, response["errorNumber"])
+		}
+	}
+}
+
+func TestServer_Batches(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.BatchSize = 2
+
+	for _, id := range []string{"a", "b", "c"} {
+		srv.AddUser(id, id+"@localhost")
+	}
+
+	first := post(t, srv, "getVPPUsersSrv", map[string]interface{}{"sToken": srv.SToken()})
+	second := post(t, srv, "getVPPUsersSrv", map[string]interface{}{"sToken": srv.SToken(), "batchToken": first["batchToken"]})
+
+	if len(first["users"].([]interface{})) != 2 || len(second["users"].([]interface{})) != 1 {
+		t.Fatalf("expected batches of 2 and 1 users, got %v and %v", first["users"], second["users"])
+	}
+
+	sinceModifiedToken, _ := second["sinceModifiedToken"].(string)
+	if sinceModifiedToken == "" {
+		t.Fatal("expected a since modified token in the final batch")
+	}
+
+	srv.AddUser("d", "d@localhost")
+	modified := post(t, srv, "getVPPUsersSrv", map[string]interface{}{"sToken": srv.SToken(), "sinceModifiedToken": sinceModifiedToken})
+	users := modified["users"].([]interface{})
+	if len(users) != 1 || users[0].(map[string]interface{})["clientUserIdStr"] != "d" {
+		t.Errorf("expected only the new user, got %v", users)
+	}
+}
+
+func TestServer_ManageLicenses(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.AddLicenses(Asset{AdamIDStr: "408709785", DeviceAssignable: true}, 1)
+
+	response := post(t, srv, "manageVPPLicensesByAdamIdSrv", map[string]interface{}{
+		"sToken":                 srv.SToken(),
+		"adamIdStr":              "408709785",
+		"pricingParam":           "STDQ",
+		"associateSerialNumbers": []string{"SERIAL1", "SERIAL2"},
+	})
+
+	associations := response["associations"].([]interface{})
+	if len(associations) != 2 {
+		t.Fatalf("expected two associations, got %v", response)
+	}
+
+	if _, failed := associations[0].(map[string]interface{})["errorNumber"]; failed {
+		t.Errorf("expected the first association to succeed, got %v", associations[0])
+	}
+
+	if number := associations[1].(map[string]interface{})["errorNumber"]; number != float64(errorNumberLicenseNotFound) {
+		t.Errorf("expected the second association to fail for lack of licenses, got %v", associations[1])
+	}
+
+	if licenses := srv.Licenses(); licenses[0].SerialNumber != "SERIAL1" {
+		t.Errorf("expected the license to be assigned, got %#v", licenses[0])
+	}
+}
+
+func TestServer_ManageLicenses_NotDeviceAssignable(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.AddLicenses(Asset{AdamIDStr: "408709785"}, 1)
+
+	response := post(t, srv, "manageVPPLicensesByAdamIdSrv", map[string]interface{}{
+		"sToken":                 srv.SToken(),
+		"adamIdStr":              "408709785",
+		"associateSerialNumbers": []string{"SERIAL1"},
+	})
+
+	associations := response["associations"].([]interface{})
+	if number := associations[0].(map[string]interface{})["errorNumber"]; number != float64(errorNumberInvalidArgument) {
+		t.Errorf("expected the association to be rejected, got %v", associations[0])
+	}
+
+	if licenses := srv.Licenses(); licenses[0].SerialNumber != "" {
+		t.Errorf("expected the license to stay unassigned, got %#v", licenses[0])
+	}
+}
+
+func TestServer_ManageLicenses_BatchLimits(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.AddLicenses(Asset{AdamIDStr: "408709785", DeviceAssignable: true}, 3)
+	srv.MaxBatchAssociateLicenseCount = 2
+	srv.MaxBatchDisassociateLicenseCount = 1
+
+	tests := map[string]map[string]interface{}{
+		"associate":    {"associateSerialNumbers": []string{"SERIAL1", "SERIAL2", "SERIAL3"}},
+		"disassociate": {"disassociateSerialNumbers": []string{"SERIAL1", "SERIAL2"}},
+	}
+
+	for name, args := range tests {
+		args["sToken"] = srv.SToken()
+		args["adamIdStr"] = "408709785"
+		response := post(t, srv, "manageVPPLicensesByAdamIdSrv", args)
+		if response["status"] != float64(-1) || response["errorNumber"] != float64(errorNumberInvalidArgument) {
+			t.Errorf("%s: expected the oversized batch to be rejected, got %v", name, response)
+		}
+	}
+
+	for _, license := range srv.Licenses() {
+		if license.SerialNumber != "" {
+			t.Errorf("expected no license to be assigned, got %#v", license)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	scenario, err := LoadScenario("../test/vppsim_retryaftersecs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	faults := scenario.Responses["getVPPUsersSrv"]
+	if len(faults) != 1 || faults[0].Count != 10 || faults[0].Response != ResponseServiceUnavailable || faults[0].RetryAfter != "20" {
+		t.Errorf("unexpected faults %#v", faults)
+	}
+
+	scenario, err = LoadScenario("../test/vppsim_fixture1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insertions := scenario.LicenseInsertions
+	if len(insertions) != 1 || insertions[0].License.AdamID != 525463029 || insertions[0].LicensesPerEvent != 5 || insertions[0].MaxEventCount != 10 {
+		t.Errorf("unexpected license insertions %#v", insertions)
+	}
+}
+
+func TestServer_Play(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	scenario := &Scenario{}
+	err := json.Unmarshal([]byte(`{
+		"getVPPUsersSrv_responses": [
+			{"count": 2, "after": 1, "response": "server_error", "status_code": 502},
+			{"count": 1, "response": "malformed"},
+			{"count": 1, "response": "vpp_error", "error_number": 9603, "error_message": "Internal error"}
+		],
+		"license_insertions": [
+			{"initial_delay_in_seconds": 0.01, "interval_in_seconds": 0.01, "license": {"adamId": 408709785},
+			 "licenses_per_event": 2, "max_event_count": 3}
+		]
+	}`), scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Play(scenario)
+
+	request, _ := json.Marshal(map[string]interface{}{"sToken": srv.SToken()})
+	var statuses []int
+	var bodies []string
+	for i := 0; i < 6; i++ {
+		resp, err := http.Post(srv.URL+"/getVPPUsersSrv", "application/json", bytes.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+		bodies = append(bodies, body.String())
+	}
+
+	expected := []int{200, 502, 502, 200, 200, 200}
+	for i, status := range statuses {
+		if status != expected[i] {
+			t.Errorf("request %d: expected status %d, got %d", i, expected[i], status)
+		}
+	}
+
+	if json.Valid([]byte(bodies[3])) {
+		t.Errorf("expected a malformed body, got %s", bodies[3])
+	}
+
+	var vppError map[string]interface{}
+	json.Unmarshal([]byte(bodies[4]), &vppError)
+	if vppError["errorNumber"] != float64(9603) || vppError["status"] != float64(-1) {
+		t.Errorf("expected a VPP error, got %s", bodies[4])
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(srv.Licenses()) < 6 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if count := len(srv.Licenses()); count != 6 {
+		t.Errorf("expected 6 licenses to be inserted over time, got %d", count)
+	}
+}